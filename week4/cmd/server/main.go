@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
+	"geek-time/week4/interface"
 	"geek-time/week4/internal/global"
 	"geek-time/week4/internal/setting"
+	"geek-time/week5/breaker"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+//熔断器快照文件，重启时恢复熔断状态，避免重启后流量直接打到故障的依赖
+const breakerSnapshotPath = "storage/breaker/snapshot.json"
+
 func init() {
 	err := setupSetting()
 	if err != nil {
@@ -27,7 +35,58 @@ func main() {
 		WriteTimeout:   global.ServerSetting.WriteTimeout,
 		MaxHeaderBytes: 1 << 20,
 	}
-	_ = s.ListenAndServe()
+	//管理端口，熔断器管理接口及指标只在这里提供，未配置AdminAddr时不启动
+	var adminServer *http.Server
+	if global.ServerSetting.AdminAddr != "" {
+		adminServer = &http.Server{
+			Addr:    global.ServerSetting.AdminAddr,
+			Handler: routers.NewAdminRouter(),
+		}
+	}
+
+	snapshotter := breaker.NewSnapshotter(breaker.SnapshotSetting{Path: breakerSnapshotPath})
+	if n, err := snapshotter.Restore(); err != nil {
+		log.Printf("breaker snapshot restore err: %v", err)
+	} else {
+		log.Printf("breaker snapshot restored: %d", n)
+	}
+	snapshotter.Start()
+
+	//熔断器事件审计日志
+	eventSink, err := breaker.NewEventFileSink(global.AppSetting.LogSavePath + "/breaker_events.jsonl")
+	if err != nil {
+		log.Printf("breaker event sink err: %v", err)
+	} else {
+		defer eventSink.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("ListenAndServe err: %v", err)
+			stop()
+		}
+	}()
+	if adminServer != nil {
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("admin ListenAndServe err: %v", err)
+				stop()
+			}
+		}()
+	}
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.Shutdown(shutdownCtx)
+	if adminServer != nil {
+		_ = adminServer.Shutdown(shutdownCtx)
+	}
+	if err := snapshotter.Stop(); err != nil {
+		log.Printf("breaker snapshot save err: %v", err)
+	}
 }
 
 func setupSetting() error {
@@ -51,6 +110,17 @@ func setupSetting() error {
 		return err
 	}
 
+	err = setupBreakerSetting(settings)
+	if err != nil {
+		return err
+	}
+	//配置文件变化时热更新熔断器配置
+	settings.WatchSettingChange(func() {
+		if err := setupBreakerSetting(settings); err != nil {
+			log.Printf("setupBreakerSetting err: %v", err)
+		}
+	})
+
 	global.ServerSetting.ReadTimeout *= time.Second
 	global.ServerSetting.WriteTimeout *= time.Second
 
@@ -60,3 +130,12 @@ func setupSetting() error {
 
 	return nil
 }
+
+func setupBreakerSetting(settings *setting.Setting) error {
+	var breakerConfig breaker.Config
+	err := settings.ReadSection("Breaker", &breakerConfig)
+	if err != nil {
+		return err
+	}
+	return breaker.ApplyConfig(breakerConfig)
+}
@@ -2,6 +2,7 @@ package routers
 
 import (
 	"geek-time/week4/interface/v1"
+	"geek-time/week5/breaker"
 	"github.com/gin-gonic/gin"
 )
 
@@ -9,13 +10,29 @@ func NewRouter(hello v1.Hello) *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
+	// 服务端过载保护
+	r.Use(breaker.LoadSheddingMiddleware(breaker.NewBBRLimiter(breaker.BBRSetting{})))
 
 	apiV1 := r.Group("/interface/v1")
 
 	{
 		// 路由
-		apiV1.GET("/hello", hello.Get)
+		apiV1.GET("/hello", breaker.GinMiddleware(nil), hello.Get)
 	}
 
 	return r
 }
+
+// NewAdminRouter 管理端口的路由，只监听内网地址，管理接口可以改变熔断状态，不挂在业务端口上
+func NewAdminRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	// 熔断器指标
+	r.GET("/metrics", gin.WrapH(breaker.MetricsHandler()))
+
+	// 熔断器管理接口
+	breaker.RegisterAdminRoutes(r.Group("/admin"))
+
+	return r
+}
@@ -5,6 +5,7 @@ import "time"
 type ServerSettings struct {
 	RunMode      string
 	HttpPort     string
+	AdminAddr    string
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 }
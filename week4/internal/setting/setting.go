@@ -1,6 +1,9 @@
 package setting
 
-import "github.com/spf13/viper"
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
 
 type Setting struct {
 	vp *viper.Viper
@@ -17,3 +20,11 @@ func NewSetting() (*Setting, error) {
 	}
 	return &Setting{vp}, nil
 }
+
+//WatchSettingChange 监听配置文件，文件变化并重新读取后调用onChange
+func (s *Setting) WatchSettingChange(onChange func()) {
+	s.vp.OnConfigChange(func(in fsnotify.Event) {
+		onChange()
+	})
+	s.vp.WatchConfig()
+}
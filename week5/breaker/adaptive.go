@@ -0,0 +1,23 @@
+package breaker
+
+//自适应限流的拒绝概率，requests为窗口内全部请求数，包括本地拒绝的请求，accepts为后端成功处理的请求数
+func (broker *breaker) rejectProbability() float64 {
+	stat := broker.counter.GetWindowStat()
+	requests := float64(stat.Total + stat.Rejected)
+	accepts := float64(stat.Total - stat.Failed)
+	probability := (requests - broker.adaptiveK*accepts) / (requests + 1)
+	if probability < 0 {
+		return 0
+	}
+	return probability
+}
+
+//自适应限流判断是否放行，被拒绝的请求单独计数并计入请求数，使拒绝概率随后端恢复平滑下降
+//拒绝的请求没有调用后端，不计为失败，也不计入耗时直方图
+func (broker *breaker) adaptiveAllow() error {
+	if broker.random() < broker.rejectProbability() {
+		broker.counter.AddRejected()
+		return OpenError
+	}
+	return nil
+}
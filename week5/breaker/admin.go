@@ -0,0 +1,178 @@
+package breaker
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	//未强制
+	forcedNone int32 = iota
+
+	//强制打开
+	forcedOpen
+
+	//强制关闭
+	forcedClosed
+)
+
+//强制状态名
+func forcedName(forced int32) string {
+	switch forced {
+	case forcedOpen:
+		return "open"
+	case forcedClosed:
+		return "closed"
+	}
+	return ""
+}
+
+//SettingView 熔断器配置展示
+type SettingView struct {
+	Interval                     string  `json:"interval"`
+	GridNum                      int     `json:"grid_num"`
+	SleepWindow                  string  `json:"sleep_window"`
+	BreakerTestMax               int     `json:"breaker_test_max"`
+	ErrorPercentThreshold        int     `json:"error_percent_threshold"`
+	BreakerErrorPercentThreshold int     `json:"breaker_error_percent_threshold"`
+	MinRequests                  int     `json:"min_requests"`
+	SlowCallDuration             string  `json:"slow_call_duration"`
+	SlowCallPercent              int     `json:"slow_call_percent"`
+	AdaptiveK                    float64 `json:"adaptive_k,omitempty"`
+}
+
+//BreakerInfo 熔断器状态展示
+type BreakerInfo struct {
+	Name            string      `json:"name"`
+	State           string      `json:"state"`
+	Forced          string      `json:"forced,omitempty"`
+	Window          WindowStat  `json:"window"`
+	HalfOpenTickets int         `json:"half_open_tickets"`
+	Latency         LatencyStat `json:"latency"`
+	Setting         SettingView `json:"setting"`
+}
+
+//返回熔断器状态展示
+func (broker *breaker) info() BreakerInfo {
+	setting := broker.setting
+	return BreakerInfo{
+		Name:            broker.name,
+		State:           StatusName(broker.State()),
+		Forced:          forcedName(atomic.LoadInt32(&broker.forced)),
+		Window:          broker.counter.GetWindowStat(),
+		HalfOpenTickets: broker.lpm.GetRemainder(),
+		Latency:         broker.counter.GetLatencyStat(),
+		Setting: SettingView{
+			Interval:                     setting.Interval.String(),
+			GridNum:                      setting.GridNum,
+			SleepWindow:                  setting.SleepWindow.String(),
+			BreakerTestMax:               setting.BreakerTestMax,
+			ErrorPercentThreshold:        setting.ErrorPercentThreshold,
+			BreakerErrorPercentThreshold: setting.BreakerErrorPercentThreshold,
+			MinRequests:                  setting.MinRequests,
+			SlowCallDuration:             setting.SlowCallDuration.String(),
+			SlowCallPercent:              setting.SlowCallPercent,
+			AdaptiveK:                    setting.AdaptiveK,
+		},
+	}
+}
+
+//从管理器查找熔断器，不会创建新的熔断器
+func (breakerManager *breakerManager) get(name string) (*breaker, bool) {
+	breakerManager.mutex.RLock()
+	defer breakerManager.mutex.RUnlock()
+	broker, ok := breakerManager.manager[name]
+	return broker, ok
+}
+
+//设置指定熔断器的强制状态
+func (breakerManager *breakerManager) force(name string, forced int32) bool {
+	broker, ok := breakerManager.get(name)
+	if !ok {
+		return false
+	}
+	atomic.StoreInt32(&broker.forced, forced)
+	switch forced {
+	case forcedOpen:
+		broker.emitTransition(EventForceOpen, broker.counter.GetStatus(), StatusOpen, reasonAdmin)
+	case forcedClosed:
+		broker.emitTransition(EventForceClose, broker.counter.GetStatus(), StatusClosed, reasonAdmin)
+	}
+	return true
+}
+
+//ForceOpen 强制打开熔断器，所有请求直接降级，调用Reset后恢复
+func ForceOpen(name string) bool {
+	return bm.force(name, forcedOpen)
+}
+
+//ForceClose 强制关闭熔断器，请求不再被熔断，调用Reset后恢复
+func ForceClose(name string) bool {
+	return bm.force(name, forcedClosed)
+}
+
+//Infos 返回全部熔断器的状态
+func Infos() []BreakerInfo {
+	breakers := bm.list()
+	infos := make([]BreakerInfo, 0, len(breakers))
+	for _, broker := range breakers {
+		infos = append(infos, broker.info())
+	}
+	return infos
+}
+
+//RegisterAdminRoutes 在路由组上注册熔断器管理接口，熔断器名称通过name查询参数传递，可以包含/
+//GET /breakers 查看全部熔断器，带name参数时查看指定熔断器
+//POST /breakers/open 强制打开，POST /breakers/close 强制关闭，POST /breakers/reset 重置
+//GET /events 以Server-Sent Events推送熔断器事件
+//管理接口可以改变熔断状态，应挂载在单独的内网端口或加上鉴权，不要暴露在业务端口上
+func RegisterAdminRoutes(group *gin.RouterGroup) {
+	group.GET("/breakers", func(c *gin.Context) {
+		name, ok := c.GetQuery("name")
+		if !ok {
+			c.JSON(http.StatusOK, gin.H{
+				"message": "success",
+				"data":    Infos(),
+			})
+			return
+		}
+		broker, ok := bm.get(name)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"message": "breaker not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "success",
+			"data":    broker.info(),
+		})
+	})
+	group.POST("/breakers/open", adminAction(ForceOpen))
+	group.POST("/breakers/close", adminAction(ForceClose))
+	group.POST("/breakers/reset", adminAction(Reset))
+	group.GET("/events", EventsHandler())
+}
+
+//包装管理操作
+func adminAction(action func(name string) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Query("name")
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "name required"})
+			return
+		}
+		if !action(name) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "breaker not found"})
+			return
+		}
+		broker, ok := bm.get(name)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"message": "breaker not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "success",
+			"data":    broker.info(),
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package breaker
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+//发送管理请求并返回状态码及熔断器状态
+func adminRequest(t *testing.T, router *gin.Engine, method string, path string, name string) (int, BreakerInfo) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path+"?name="+url.QueryEscape(name), nil)
+	router.ServeHTTP(w, req)
+	var body struct {
+		Data BreakerInfo `json:"data"`
+	}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+	}
+	return w.Code, body.Data
+}
+
+func TestAdminRoutesWithSlashName(t *testing.T) {
+	//与GinMiddleware一样以路由路径作为名称
+	name := "/interface/v1/hello"
+	if _, err := Register(name); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	t.Cleanup(func() {
+		Remove(name)
+	})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterAdminRoutes(router.Group("/admin"))
+
+	if code, info := adminRequest(t, router, http.MethodGet, "/admin/breakers", name); code != http.StatusOK || info.Name != name {
+		t.Fatalf("GET = %d %q, want 200 %q", code, info.Name, name)
+	}
+	if code, info := adminRequest(t, router, http.MethodPost, "/admin/breakers/open", name); code != http.StatusOK || info.State != "open" {
+		t.Fatalf("POST open = %d %q, want 200 open", code, info.State)
+	}
+	if code, info := adminRequest(t, router, http.MethodPost, "/admin/breakers/reset", name); code != http.StatusOK || info.State != "closed" {
+		t.Fatalf("POST reset = %d %q, want 200 closed", code, info.State)
+	}
+	if code, _ := adminRequest(t, router, http.MethodPost, "/admin/breakers/close", "/missing"); code != http.StatusNotFound {
+		t.Fatalf("POST close missing = %d, want 404", code)
+	}
+	if code, _ := adminRequest(t, router, http.MethodPost, "/admin/breakers/close", ""); code != http.StatusBadRequest {
+		t.Fatalf("POST close without name = %d, want 400", code)
+	}
+}
@@ -0,0 +1,222 @@
+package breaker
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+//OverloadError 服务端过载，请求被丢弃
+var OverloadError = errors.New("server overload")
+
+var (
+	DefaultBBRWindow             = 10 * time.Second
+	DefaultBBRGridNum            = 100
+	DefaultBBRCPUThreshold int64 = 800
+	DefaultBBRCoolDown           = time.Second
+	DefaultBBRMinFlight    int64 = 50
+)
+
+//BBRSetting 自适应过载保护设置
+type BBRSetting struct {
+	//统计周期
+	Window time.Duration
+
+	//格子数
+	GridNum int
+
+	//CPU使用率千分比阈值，超过后开始按最大吞吐量丢弃请求
+	CPUThreshold int64
+
+	//丢弃请求后的冷却时间，冷却期内即使CPU回落仍按最大吞吐量判断
+	CoolDown time.Duration
+
+	//最大在途请求数的下限，启动后还没有统计数据时估算值接近0，避免CPU一超过阈值就丢弃几乎所有请求
+	MinFlight int64
+
+	//时钟，为空时使用DefaultClock
+	Clock Clock
+
+	//CPU使用率，为空时使用CPUUsage，测试时可替换
+	CPUUsage func() int64
+}
+
+//BBR自适应过载保护，CPU超过阈值且在途请求数超过估算的最大吞吐量时丢弃请求
+//最大吞吐量 = 单个格子最大通过数 * 每秒格子数 * 最小平均耗时
+type bbrLimiter struct {
+	//格子环，total记录完成的请求数，duration记录总耗时
+	ring *gridRing
+
+	//格子时间，单位纳秒
+	gridTime int64
+
+	//CPU阈值
+	cpuThreshold int64
+
+	//冷却时间，单位纳秒
+	coolDown int64
+
+	//最大在途请求数的下限
+	minFlight int64
+
+	//在途请求数
+	inFlight int64
+
+	//上次丢弃请求的时间，单位纳秒
+	prevDropTime int64
+
+	//时钟
+	clock Clock
+
+	//CPU使用率
+	cpuUsage func() int64
+}
+
+//NewBBRLimiter 创建自适应过载保护，未设置的字段使用默认值
+func NewBBRLimiter(setting BBRSetting) *bbrLimiter {
+	if setting.Window <= 0 {
+		setting.Window = DefaultBBRWindow
+	}
+	if setting.GridNum <= 0 {
+		setting.GridNum = DefaultBBRGridNum
+	}
+	if setting.CPUThreshold <= 0 {
+		setting.CPUThreshold = DefaultBBRCPUThreshold
+	}
+	if setting.CoolDown <= 0 {
+		setting.CoolDown = DefaultBBRCoolDown
+	}
+	if setting.MinFlight <= 0 {
+		setting.MinFlight = DefaultBBRMinFlight
+	}
+	if setting.Clock == nil {
+		setting.Clock = DefaultClock
+	}
+	if setting.CPUUsage == nil {
+		startCPUSampler()
+		setting.CPUUsage = CPUUsage
+	}
+	gridTime := int64(setting.Window) / int64(setting.GridNum)
+	return &bbrLimiter{
+		ring:         newGridRing(setting.GridNum, gridTime, false),
+		gridTime:     gridTime,
+		cpuThreshold: setting.CPUThreshold,
+		coolDown:     int64(setting.CoolDown),
+		minFlight:    setting.MinFlight,
+		clock:        setting.Clock,
+		cpuUsage:     setting.CPUUsage,
+	}
+}
+
+//单个已完成格子的最大通过数，不包含当前格子
+func (limiter *bbrLimiter) maxPass(now int64) uint32 {
+	var maxPass uint32 = 1
+	epoch := now / limiter.gridTime
+	limiter.ring.forEach(now, func(data *gridData) {
+		if data.epoch == epoch {
+			return
+		}
+		if pass := atomic.LoadUint32(&data.total); pass > maxPass {
+			maxPass = pass
+		}
+	})
+	return maxPass
+}
+
+//格子内最小平均耗时，单位毫秒，不包含当前格子
+func (limiter *bbrLimiter) minRT(now int64) float64 {
+	minRT := math.MaxFloat64
+	epoch := now / limiter.gridTime
+	limiter.ring.forEach(now, func(data *gridData) {
+		if data.epoch == epoch {
+			return
+		}
+		pass := atomic.LoadUint32(&data.total)
+		if pass == 0 {
+			return
+		}
+		rt := float64(atomic.LoadInt64(&data.duration)) / float64(pass) / float64(time.Millisecond)
+		if rt < minRT {
+			minRT = rt
+		}
+	})
+	if minRT == math.MaxFloat64 {
+		return 1
+	}
+	return minRT
+}
+
+//MaxFlight 估算的最大在途请求数，不小于MinFlight
+func (limiter *bbrLimiter) MaxFlight() int64 {
+	now := limiter.clock.Now().UnixNano()
+	gridsPerSecond := float64(time.Second) / float64(limiter.gridTime)
+	maxFlight := int64(math.Floor(float64(limiter.maxPass(now))*limiter.minRT(now)*gridsPerSecond/1000 + 0.5))
+	if maxFlight < limiter.minFlight {
+		return limiter.minFlight
+	}
+	return maxFlight
+}
+
+//InFlight 返回在途请求数
+func (limiter *bbrLimiter) InFlight() int64 {
+	return atomic.LoadInt64(&limiter.inFlight)
+}
+
+//判断是否丢弃请求
+func (limiter *bbrLimiter) shouldDrop() bool {
+	now := limiter.clock.Now().UnixNano()
+	inFlight := atomic.LoadInt64(&limiter.inFlight)
+	if limiter.cpuUsage() < limiter.cpuThreshold {
+		prevDropTime := atomic.LoadInt64(&limiter.prevDropTime)
+		if prevDropTime == 0 {
+			return false
+		}
+		if now-prevDropTime <= limiter.coolDown {
+			return inFlight > limiter.MaxFlight()
+		}
+		atomic.StoreInt64(&limiter.prevDropTime, 0)
+		return false
+	}
+	if inFlight > limiter.MaxFlight() {
+		atomic.StoreInt64(&limiter.prevDropTime, now)
+		return true
+	}
+	return false
+}
+
+//Allow 判断是否放行，放行时返回的done需要在请求结束后调用
+func (limiter *bbrLimiter) Allow() (func(), error) {
+	if limiter.shouldDrop() {
+		return nil, OverloadError
+	}
+	atomic.AddInt64(&limiter.inFlight, 1)
+	start := limiter.clock.Now()
+	return func() {
+		now := limiter.clock.Now()
+		if data := limiter.ring.current(now.UnixNano()); data != nil {
+			atomic.AddUint32(&data.total, 1)
+			atomic.AddInt64(&data.duration, int64(now.Sub(start)))
+		}
+		atomic.AddInt64(&limiter.inFlight, -1)
+	}, nil
+}
+
+//LoadSheddingMiddleware 使用自适应过载保护丢弃请求，丢弃时调用fallback并终止后续处理，未传fallback时使用DefaultGinFallback返回503
+func LoadSheddingMiddleware(limiter *bbrLimiter, fallback ...GinFallbackFunc) gin.HandlerFunc {
+	fallbackFn := DefaultGinFallback
+	if len(fallback) > 0 && fallback[0] != nil {
+		fallbackFn = fallback[0]
+	}
+	return func(c *gin.Context) {
+		done, err := limiter.Allow()
+		if err != nil {
+			fallbackFn(c, err)
+			c.Abort()
+			return
+		}
+		defer done()
+		c.Next()
+	}
+}
@@ -0,0 +1,260 @@
+package breaker
+
+import (
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//创建使用手动时钟及可控CPU使用率的过载保护，周期1秒共10个格子
+func newTestBBRLimiter(minFlight int64) (*bbrLimiter, *ManualClock, *int64) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	cpu := new(int64)
+	limiter := NewBBRLimiter(BBRSetting{
+		Window:    time.Second,
+		GridNum:   10,
+		CoolDown:  300 * time.Millisecond,
+		MinFlight: minFlight,
+		Clock:     clock,
+		CPUUsage: func() int64 {
+			return atomic.LoadInt64(cpu)
+		},
+	})
+	return limiter, clock, cpu
+}
+
+//在当前格子内完成n个耗时为duration的请求，然后推进到下一个格子
+func fillBBRGrid(t *testing.T, limiter *bbrLimiter, clock *ManualClock, n int, duration time.Duration) {
+	t.Helper()
+	dones := make([]func(), 0, n)
+	for i := 0; i < n; i++ {
+		done, err := limiter.Allow()
+		if err != nil {
+			t.Fatalf("Allow() error = %v", err)
+		}
+		dones = append(dones, done)
+	}
+	clock.Advance(duration)
+	for _, done := range dones {
+		done()
+	}
+	clock.Advance(100*time.Millisecond - duration)
+}
+
+//放行n个请求且不结束
+func holdBBR(t *testing.T, limiter *bbrLimiter, n int) []func() {
+	t.Helper()
+	dones := make([]func(), 0, n)
+	for i := 0; i < n; i++ {
+		done, err := limiter.Allow()
+		if err != nil {
+			t.Fatalf("Allow() #%d error = %v", i, err)
+		}
+		dones = append(dones, done)
+	}
+	return dones
+}
+
+func TestBBRMaxFlight(t *testing.T) {
+	limiter, clock, _ := newTestBBRLimiter(5)
+	//没有统计数据时使用下限
+	if got := limiter.MaxFlight(); got != 5 {
+		t.Fatalf("MaxFlight() = %d, want 5", got)
+	}
+
+	//格子内最多通过50个，平均耗时20ms，每秒10个格子：50 * 20ms * 10 / 1000 = 10
+	fillBBRGrid(t, limiter, clock, 50, 20*time.Millisecond)
+	if got := limiter.MaxFlight(); got != 10 {
+		t.Fatalf("MaxFlight() = %d, want 10", got)
+	}
+
+	//统计数据移出窗口后回到下限
+	clock.Advance(time.Second)
+	if got := limiter.MaxFlight(); got != 5 {
+		t.Fatalf("MaxFlight() after window = %d, want 5", got)
+	}
+}
+
+func TestBBRWarmUpFloor(t *testing.T) {
+	limiter, _, cpu := newTestBBRLimiter(0)
+	if got := limiter.MaxFlight(); got != DefaultBBRMinFlight {
+		t.Fatalf("MaxFlight() = %d, want %d", got, DefaultBBRMinFlight)
+	}
+
+	//刚启动时CPU超过阈值，下限以内的请求不会被丢弃
+	atomic.StoreInt64(cpu, 900)
+	holdBBR(t, limiter, int(DefaultBBRMinFlight)+1)
+	if _, err := limiter.Allow(); err != OverloadError {
+		t.Fatalf("Allow() error = %v, want OverloadError", err)
+	}
+}
+
+func TestBBRShouldDropAndCoolDown(t *testing.T) {
+	limiter, clock, cpu := newTestBBRLimiter(5)
+	fillBBRGrid(t, limiter, clock, 50, 20*time.Millisecond)
+
+	//CPU未超过阈值时不丢弃
+	dones := holdBBR(t, limiter, 20)
+	clock.Advance(50 * time.Millisecond)
+	for _, done := range dones {
+		done()
+	}
+
+	//CPU超过阈值后在途请求数超过10时丢弃
+	atomic.StoreInt64(cpu, 900)
+	dones = holdBBR(t, limiter, 11)
+	if _, err := limiter.Allow(); err != OverloadError {
+		t.Fatalf("Allow() error = %v, want OverloadError", err)
+	}
+
+	//冷却期内CPU回落仍按最大在途请求数丢弃
+	atomic.StoreInt64(cpu, 100)
+	clock.Advance(200 * time.Millisecond)
+	if _, err := limiter.Allow(); err != OverloadError {
+		t.Fatalf("Allow() in cool down error = %v, want OverloadError", err)
+	}
+	//在途请求数回落后放行
+	dones[0]()
+	done, err := limiter.Allow()
+	if err != nil {
+		t.Fatalf("Allow() in cool down error = %v, want nil", err)
+	}
+	dones[0] = done
+
+	//冷却期结束后不再丢弃
+	clock.Advance(200 * time.Millisecond)
+	dones = append(dones, holdBBR(t, limiter, 5)...)
+	if got := limiter.InFlight(); got != 16 {
+		t.Fatalf("InFlight() = %d, want 16", got)
+	}
+	if prevDropTime := atomic.LoadInt64(&limiter.prevDropTime); prevDropTime != 0 {
+		t.Fatalf("prevDropTime = %d, want 0", prevDropTime)
+	}
+	for _, done := range dones {
+		done()
+	}
+	if got := limiter.InFlight(); got != 0 {
+		t.Fatalf("InFlight() = %d, want 0", got)
+	}
+}
+
+func TestLoadSheddingMiddlewareAborts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter, _, cpu := newTestBBRLimiter(1)
+	atomic.StoreInt64(cpu, 900)
+	holdBBR(t, limiter, 2)
+
+	var handled int32
+	router := gin.New()
+	//自定义fallback未调用Abort
+	router.Use(LoadSheddingMiddleware(limiter, func(c *gin.Context, err error) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+	}))
+	router.GET("/", func(c *gin.Context) {
+		atomic.AddInt32(&handled, 1)
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", recorder.Code)
+	}
+	if atomic.LoadInt32(&handled) != 0 {
+		t.Fatal("handler ran after load shedding")
+	}
+}
+
+//在临时目录中写入cgroup文件
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(root)
+	})
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	return root
+}
+
+func TestReadCgroupCPU(t *testing.T) {
+	hostCPU := float64(runtime.NumCPU())
+	cases := []struct {
+		name  string
+		files map[string]string
+		usage time.Duration
+		limit float64
+	}{
+		{"v2 quota", map[string]string{
+			"cpu.stat": "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n",
+			"cpu.max":  "200000 100000\n",
+		}, 1500 * time.Millisecond, 2},
+		{"v2 unlimited", map[string]string{
+			"cpu.stat": "usage_usec 20\n",
+			"cpu.max":  "max 100000\n",
+		}, 20 * time.Microsecond, hostCPU},
+		{"v1 quota", map[string]string{
+			"cpuacct/cpuacct.usage": "2500000000\n",
+			"cpu/cpu.cfs_quota_us":  "50000\n",
+			"cpu/cpu.cfs_period_us": "100000\n",
+		}, 2500 * time.Millisecond, 0.5},
+		{"v1 unlimited", map[string]string{
+			"cpu,cpuacct/cpuacct.usage":     "1000\n",
+			"cpu,cpuacct/cpu.cfs_quota_us":  "-1\n",
+			"cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+		}, 1000, hostCPU},
+	}
+	for _, tc := range cases {
+		cpu, err := readCgroupCPU(writeCgroupFiles(t, tc.files))
+		if err != nil {
+			t.Fatalf("%s: readCgroupCPU() error = %v", tc.name, err)
+		}
+		if cpu.usage != tc.usage || cpu.limit != tc.limit {
+			t.Fatalf("%s: readCgroupCPU() = %+v, want usage %v limit %v", tc.name, cpu, tc.usage, tc.limit)
+		}
+	}
+
+	if _, err := readCgroupCPU(writeCgroupFiles(t, map[string]string{"cpu.stat": "user_usec 1\n"})); err == nil {
+		t.Fatal("readCgroupCPU() without usage error = nil")
+	}
+}
+
+func TestCgroupCPUSampler(t *testing.T) {
+	root := writeCgroupFiles(t, map[string]string{
+		"cpu.stat": "usage_usec 0\n",
+		"cpu.max":  "100000 100000\n",
+	})
+	sample, err := newCPUSampler(root)
+	if err != nil {
+		t.Fatalf("newCPUSampler() error = %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if usage, ok := sample(); !ok || usage != 0 {
+		t.Fatalf("sample() = %v, %v, want 0, true", usage, ok)
+	}
+
+	//用量超过配额时按1000计
+	if err := ioutil.WriteFile(filepath.Join(root, "cpu.stat"), []byte("usage_usec 100000000\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if usage, ok := sample(); !ok || usage != 1000 {
+		t.Fatalf("sample() = %v, %v, want 1000, true", usage, ok)
+	}
+}
@@ -3,6 +3,8 @@ package breaker
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,6 +24,12 @@ var (
 
 	//NameNilError 策略名为空
 	NameNilError = errors.New("name nil")
+
+	//PanicError run函数发生panic，恢复后计为一次失败
+	PanicError = errors.New("run panic")
+
+	//ClosedError 熔断器已关闭且管理器中没有可用的同名熔断器
+	ClosedError = errors.New("breaker closed")
 )
 
 //熔断器
@@ -30,9 +38,9 @@ type breaker struct {
 	name string
 
 	//熔断休眠时间
-	sleepWindow int64
+	sleepWindow time.Duration
 
-	//周期管理
+	//周期管理，休眠结束的时间，单位纳秒
 	cycleTime int64
 
 	//统计器
@@ -40,6 +48,42 @@ type breaker struct {
 
 	//熔断时令牌桶
 	lpm *limitPoolManager
+
+	//失败判定函数
+	isFailure func(error) bool
+
+	//不计为失败的错误
+	ignoreErrors []error
+
+	//时钟
+	clock Clock
+
+	//创建时的配置，重置时使用
+	setting *breakSettingInfo
+
+	//最近一次使用时间，单位纳秒
+	lastUsed int64
+
+	//是否已关闭
+	closed int32
+
+	//累计指标
+	metrics breakerMetrics
+
+	//人工强制状态
+	forced int32
+
+	//自适应限流系数K，为0时使用熔断模式
+	adaptiveK float64
+
+	//自适应限流使用的随机数函数
+	random func() float64
+
+	//舱壁隔离，为空时不限制并发
+	bulkhead *bulkhead
+
+	//是否由配置或默认值创建，应用新配置时重建
+	configured bool
 }
 
 //熔断器管理器
@@ -49,6 +93,12 @@ type breakerManager struct {
 
 	//Breaker集合
 	manager map[string]*breaker
+
+	//空闲淘汰的停止通道
+	evictStop chan struct{}
+
+	//熔断器配置，为空时使用默认值
+	config *Config
 }
 
 //定义全局熔断器管理器
@@ -64,23 +114,49 @@ func init() {
 //执行函数
 type runFunc func() error
 
+//包装后的执行函数，返回最后一次执行的耗时及错误
+type execFunc func() (time.Duration, error)
+
 //回调函数
 type fallbackFunc func(error)
 
 //方法创建一个熔断器
 func newBreaker(b *breakSettingInfo) *breaker {
 	lpm := NewLimitPoolManager(b.BreakerTestMax)
-	counter := NewSlidingWindow(SlidingWindowSetting{CycleTime: b.Interval,
+	counter := newSlidingWindow(SlidingWindowSetting{CycleTime: b.Interval,
+		GridNum:              b.GridNum,
 		ErrorPercent:         b.ErrorPercentThreshold,
+		TripStrategy:         b.TripStrategy,
+		MinRequests:          b.MinRequests,
 		HalfOpenErrorPercent: b.BreakerErrorPercentThreshold,
 		RecoverNum:           b.BreakerTestMax,
+		SlowCallDuration:     b.SlowCallDuration,
+		SlowCallPercent:      b.SlowCallPercent,
+		Clock:                b.Clock,
+		StatOnly:             b.AdaptiveK > 0,
 	})
+	random := b.Random
+	if random == nil {
+		random = rand.Float64
+	}
+	var bulkhead *bulkhead
+	if b.MaxConcurrent > 0 {
+		bulkhead = newBulkhead(b.MaxConcurrent, b.MaxQueue, b.QueueTimeout, b.Clock)
+	}
 	return &breaker{
-		name:        b.Name,
-		cycleTime:   time.Now().Local().Unix() + b.SleepWindow,
-		sleepWindow: b.SleepWindow,
-		counter:     counter,
-		lpm:         lpm,
+		name:         b.Name,
+		cycleTime:    b.Clock.Now().Add(b.SleepWindow).UnixNano(),
+		sleepWindow:  b.SleepWindow,
+		counter:      counter,
+		lpm:          lpm,
+		isFailure:    b.IsFailure,
+		ignoreErrors: b.IgnoreErrors,
+		clock:        b.Clock,
+		setting:      b.clone(),
+		lastUsed:     b.Clock.Now().UnixNano(),
+		adaptiveK:    b.AdaptiveK,
+		random:       random,
+		bulkhead:     bulkhead,
 	}
 }
 
@@ -98,10 +174,11 @@ func getBreakerManager(name string) (*breaker, error) {
 		if breaker, ok := bm.manager[name]; ok {
 			return breaker, nil
 		}
-		breakInfo, err := NewBreakSettingInfo().SetName(name).AddBreakSetting()
+		breakInfo, err := bm.config.settingFor(name).build()
 		if err != nil {
 			return nil, err
 		}
+		breakInfo.configured = true
 		bm.manager[name] = breakInfo
 		return breakInfo, nil
 	} else {
@@ -111,39 +188,100 @@ func getBreakerManager(name string) (*breaker, error) {
 }
 
 //方法失败处理
-func (broker *breaker) fail() {
+func (broker *breaker) fail(duration time.Duration) {
 	state := broker.counter.GetStatus()
 	switch state {
 	case StatusClosed:
-		atomic.StoreInt64(&broker.cycleTime, time.Now().Local().Unix()+broker.sleepWindow)
-		broker.counter.AddForClose(false)
+		atomic.StoreInt64(&broker.cycleTime, broker.clock.Now().Add(broker.sleepWindow).UnixNano())
+		if broker.counter.AddForClose(false, duration) {
+			broker.emitTransition(EventTrip, StatusClosed, StatusOpen, reasonWindow)
+		}
 	case StatusOpen:
-		if time.Now().Local().Unix() > atomic.LoadInt64(&broker.cycleTime) {
+		if broker.clock.Now().UnixNano() > atomic.LoadInt64(&broker.cycleTime) {
 			if broker.counter.AddForOpen(false) {
 				defer broker.lpm.ReturnAll()
-				atomic.StoreInt64(&broker.cycleTime, time.Now().Local().Unix()+broker.sleepWindow)
+				atomic.StoreInt64(&broker.cycleTime, broker.clock.Now().Add(broker.sleepWindow).UnixNano())
+				broker.emitHalfOpenResult()
 			}
 		}
 	}
 }
 
-//方法成功处理
-func (broker *breaker) success() {
+//方法成功处理，半开启时慢调用按失败计数
+func (broker *breaker) success(duration time.Duration) {
 	state := broker.counter.GetStatus()
 	switch state {
 	case StatusClosed:
-		atomic.StoreInt64(&broker.cycleTime, time.Now().Local().Unix()+broker.sleepWindow)
-		broker.counter.AddForClose(true)
+		atomic.StoreInt64(&broker.cycleTime, broker.clock.Now().Add(broker.sleepWindow).UnixNano())
+		if broker.counter.AddForClose(true, duration) {
+			broker.emitTransition(EventTrip, StatusClosed, StatusOpen, reasonWindow)
+		}
 	case StatusOpen:
-		if time.Now().Local().Unix() > atomic.LoadInt64(&broker.cycleTime) {
-			if broker.counter.AddForOpen(true) {
+		if broker.clock.Now().UnixNano() > atomic.LoadInt64(&broker.cycleTime) {
+			if broker.counter.AddForOpen(!broker.counter.IsSlow(duration)) {
 				defer broker.lpm.ReturnAll()
-				atomic.StoreInt64(&broker.cycleTime, time.Now().Local().Unix()+broker.sleepWindow)
+				atomic.StoreInt64(&broker.cycleTime, broker.clock.Now().Add(broker.sleepWindow).UnixNano())
+				broker.emitHalfOpenResult()
 			}
 		}
 	}
 }
 
+//判断错误是否计为失败，panic始终计为失败，忽略列表优先于判定函数
+func (broker *breaker) isFail(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, PanicError) {
+		return true
+	}
+	for _, ignoreErr := range broker.ignoreErrors {
+		if errors.Is(err, ignoreErr) {
+			return false
+		}
+	}
+	if broker.isFailure != nil {
+		return broker.isFailure(err)
+	}
+	return true
+}
+
+//根据执行结果及耗时计数
+func (broker *breaker) report(err error, duration time.Duration) {
+	//已关闭的熔断器不再计数，执行中被重置或淘汰的调用结果不影响新的熔断器
+	if broker.IsClosed() {
+		return
+	}
+	failed := broker.isFail(err)
+	if failed {
+		atomic.AddUint64(&broker.metrics.failures, 1)
+	}
+	//人工强制及自适应限流时只统计，不参与状态流转
+	if atomic.LoadInt32(&broker.forced) != forcedNone || broker.adaptiveK > 0 {
+		if broker.counter.AddForClose(!failed, duration) {
+			broker.emitTransition(EventTrip, StatusClosed, StatusOpen, reasonWindow)
+		}
+		return
+	}
+	if failed {
+		broker.fail(duration)
+		return
+	}
+	broker.success(duration)
+}
+
+//执行run函数，将panic恢复为PanicError，同时返回执行耗时
+func (broker *breaker) safeRun(run runFunc) (duration time.Duration, err error) {
+	start := broker.clock.Now()
+	defer func() {
+		duration = broker.clock.Now().Sub(start)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", PanicError, r)
+		}
+	}()
+	return 0, run()
+}
+
 //包装外部回调函数
 func (broker *breaker) safeCallback(fallback fallbackFunc, err error) {
 	if fallback == nil {
@@ -152,11 +290,37 @@ func (broker *breaker) safeCallback(fallback fallbackFunc, err error) {
 	fallback(err)
 }
 
+//State 返回熔断器当前状态，打开且休眠结束时为半开启，人工强制时返回强制的状态
+func (broker *breaker) State() int32 {
+	switch atomic.LoadInt32(&broker.forced) {
+	case forcedOpen:
+		return StatusOpen
+	case forcedClosed:
+		return StatusClosed
+	}
+	if broker.counter.GetStatus() == StatusOpen {
+		if atomic.LoadInt64(&broker.cycleTime) < broker.clock.Now().UnixNano() {
+			return StatusHalfOpen
+		}
+		return StatusOpen
+	}
+	return StatusClosed
+}
+
 //执行方法前的处理
 func (broker *breaker) beforeDo(ctx context.Context, name string) error {
+	switch atomic.LoadInt32(&broker.forced) {
+	case forcedOpen:
+		return OpenError
+	case forcedClosed:
+		return nil
+	}
+	if broker.adaptiveK > 0 {
+		return broker.adaptiveAllow()
+	}
 	switch broker.counter.GetStatus() {
 	case StatusOpen:
-		if broker.cycleTime < time.Now().Local().Unix() {
+		if atomic.LoadInt64(&broker.cycleTime) < broker.clock.Now().UnixNano() {
 			return OpenToHalfError
 		}
 		return OpenError
@@ -165,35 +329,37 @@ func (broker *breaker) beforeDo(ctx context.Context, name string) error {
 }
 
 //执行方法后的处理
-func (broker *breaker) afterDo(ctx context.Context, run runFunc, fallback fallbackFunc, err error) error {
+func (broker *breaker) afterDo(ctx context.Context, exec execFunc, fallback fallbackFunc, err error, duration time.Duration) error {
 	switch err {
 	//熔断时
 	case OpenError:
+		atomic.AddUint64(&broker.metrics.rejected, 1)
 		broker.safeCallback(fallback, OpenError)
 		return nil
 	//熔断转移到半开启
 	case OpenToHalfError:
 		/*取令牌*/
 		if !broker.lpm.GetTicket() {
+			atomic.AddUint64(&broker.metrics.rejected, 1)
 			broker.safeCallback(fallback, OpenError)
 			return nil
 		}
+		atomic.AddUint64(&broker.metrics.probes, 1)
 		//执行方法
-		runErr := run()
+		runDuration, runErr := exec()
+		broker.emitProbe(runErr, runDuration)
+		broker.report(runErr, runDuration)
 		if runErr != nil {
-			broker.fail()
 			broker.safeCallback(fallback, runErr)
 			return runErr
 		}
-		broker.success()
 		return nil
 	default:
+		broker.report(err, duration)
 		if err != nil {
-			broker.fail()
 			broker.safeCallback(fallback, err)
 			return err
 		}
-		broker.success()
 		return nil
 	}
 }
@@ -201,6 +367,8 @@ func (broker *breaker) afterDo(ctx context.Context, run runFunc, fallback fallba
 //Do 方法结合熔断策略执行run函数
 //其中参数包括:上下文ctx,策略名name,将要执行方法run,以及回调函数fallback.其中ctx,name,run必传
 //run函数的错误会直接同步返回，回调函数fallback接收除了run错误以外还会接收熔断时错误，调用方如果需要降级可在fallback中自己判断
+//run函数panic时会被恢复并以PanicError返回，panic始终计为失败，不受配置的IsFailure和IgnoreErrors影响
+//配置舱壁隔离时并发已满的请求不会执行，fallback收到ErrBulkheadFull，该拒绝不计入滑动窗口
 func Do(ctx context.Context, name string, run runFunc, fallback fallbackFunc) error {
 	if run == nil {
 		return FuncNilError
@@ -214,14 +382,43 @@ func Do(ctx context.Context, name string, run runFunc, fallback fallbackFunc) er
 		fallback(err)
 		return err
 	}
+	return breaker.do(ctx, func() (time.Duration, error) {
+		return breaker.safeRun(run)
+	}, fallback)
+}
+
+//熔断器执行exec，每次调用只在滑动窗口中计数一次
+func (broker *breaker) do(ctx context.Context, exec execFunc, fallback fallbackFunc) error {
+	//获取后被删除、重置或淘汰时改用管理器中当前的熔断器
+	if broker.IsClosed() {
+		current, err := getBreakerManager(broker.name)
+		if err == nil && (current == broker || current.IsClosed()) {
+			err = ClosedError
+		}
+		if err != nil {
+			broker.safeCallback(fallback, err)
+			return err
+		}
+		return current.do(ctx, exec, fallback)
+	}
+	broker.touch()
+	atomic.AddUint64(&broker.metrics.requests, 1)
 	//判断当前是否可以请求
-	beforeDoErr := breaker.beforeDo(ctx, name)
+	beforeDoErr := broker.beforeDo(ctx, broker.name)
+	//舱壁隔离，熔断时不占用槽位
+	if beforeDoErr != OpenError && broker.bulkhead != nil {
+		if !broker.bulkhead.acquire(ctx) {
+			atomic.AddUint64(&broker.metrics.bulkheadRejected, 1)
+			broker.safeCallback(fallback, ErrBulkheadFull)
+			return nil
+		}
+		defer broker.bulkhead.release()
+	}
 	if beforeDoErr != nil {
 		//如果有错误直接交给afterDo处理
-		callBackErr := breaker.afterDo(ctx, run, fallback, beforeDoErr)
-		return callBackErr
+		return broker.afterDo(ctx, exec, fallback, beforeDoErr, 0)
 	}
-	runErr := run()
+	duration, runErr := exec()
 	//执行后的处理
-	return breaker.afterDo(ctx, run, fallback, runErr)
+	return broker.afterDo(ctx, exec, fallback, runErr, duration)
 }
@@ -0,0 +1,753 @@
+package breaker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errDependency = errors.New("dependency down")
+
+//注册测试用熔断器并返回手动时钟
+func newTestBreaker(t *testing.T, setting *breakSettingInfo) (*breaker, *ManualClock) {
+	t.Helper()
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	b, err := setting.SetName(t.Name()).
+		SetWindow(10*time.Second, 10).
+		SetSleepWindowDuration(time.Second).
+		SetClock(clock).
+		AddBreakSetting()
+	if err != nil {
+		t.Fatalf("AddBreakSetting() error = %v", err)
+	}
+	t.Cleanup(func() {
+		Remove(t.Name())
+	})
+	return b, clock
+}
+
+//校验熔断器状态
+func assertStatus(t *testing.T, b *breaker, want int32) {
+	t.Helper()
+	if got := b.counter.GetStatus(); got != want {
+		t.Fatalf("status = %d, want %d", got, want)
+	}
+}
+
+//执行n次run并返回fallback收到的错误
+func doN(t *testing.T, n int, run runFunc) []error {
+	t.Helper()
+	var fallbackErrs []error
+	for i := 0; i < n; i++ {
+		_ = Do(context.Background(), t.Name(), run, func(err error) {
+			fallbackErrs = append(fallbackErrs, err)
+		})
+	}
+	return fallbackErrs
+}
+
+func failRun() error {
+	return errDependency
+}
+
+func successRun() error {
+	return nil
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	b, clock := newTestBreaker(t, NewBreakSettingInfo())
+
+	//关闭 -> 打开
+	doN(t, 20, failRun)
+	assertStatus(t, b, StatusOpen)
+
+	//打开时直接降级，不执行run
+	called := false
+	errs := doN(t, 1, func() error {
+		called = true
+		return nil
+	})
+	if called {
+		t.Fatal("run called while breaker open")
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], OpenError) {
+		t.Fatalf("fallback errors = %v, want [OpenError]", errs)
+	}
+
+	//休眠结束后半开启，探测请求全部成功 -> 关闭
+	clock.Advance(time.Second + time.Millisecond)
+	if errs := doN(t, DefaultBreakerTestMax, successRun); len(errs) != 0 {
+		t.Fatalf("half-open probes fallback errors = %v", errs)
+	}
+	assertStatus(t, b, StatusClosed)
+
+	clock.Advance(10 * time.Second)
+	if errs := doN(t, 5, successRun); len(errs) != 0 {
+		t.Fatalf("closed fallback errors = %v", errs)
+	}
+}
+
+func TestBreakerHalfOpenFailureReopens(t *testing.T) {
+	b, clock := newTestBreaker(t, NewBreakSettingInfo())
+
+	doN(t, 20, failRun)
+	assertStatus(t, b, StatusOpen)
+
+	clock.Advance(time.Second + time.Millisecond)
+	doN(t, DefaultBreakerTestMax, failRun)
+	assertStatus(t, b, StatusOpen)
+
+	//重新进入休眠，半开启令牌已归还
+	errs := doN(t, 1, successRun)
+	if len(errs) != 1 || !errors.Is(errs[0], OpenError) {
+		t.Fatalf("fallback errors = %v, want [OpenError]", errs)
+	}
+	if got := b.lpm.GetRemainder(); got != DefaultBreakerTestMax {
+		t.Fatalf("tickets = %d, want %d", got, DefaultBreakerTestMax)
+	}
+}
+
+func TestBreakerHalfOpenTicketsLimitProbes(t *testing.T) {
+	b, clock := newTestBreaker(t, NewBreakSettingInfo())
+
+	doN(t, 20, failRun)
+	assertStatus(t, b, StatusOpen)
+
+	clock.Advance(time.Second + time.Millisecond)
+	for i := 0; i < DefaultBreakerTestMax; i++ {
+		if !b.lpm.GetTicket() {
+			t.Fatalf("GetTicket() = false at %d", i)
+		}
+	}
+	errs := doN(t, 1, successRun)
+	if len(errs) != 1 || !errors.Is(errs[0], OpenError) {
+		t.Fatalf("fallback errors = %v, want [OpenError]", errs)
+	}
+}
+
+func TestBreakerSubSecondWindowSmallTestMax(t *testing.T) {
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	b, err := NewBreakSettingInfo().SetName(t.Name()).
+		SetWindow(500*time.Millisecond, 50).
+		SetSleepWindowDuration(100 * time.Millisecond).
+		SetBreakerTestMax(3).
+		SetClock(clock).
+		AddBreakSetting()
+	if err != nil {
+		t.Fatalf("AddBreakSetting() error = %v", err)
+	}
+	t.Cleanup(func() {
+		Remove(t.Name())
+	})
+	//小于默认值的探测次数不会被提升
+	if got := b.lpm.GetRemainder(); got != 3 {
+		t.Fatalf("tickets = %d, want 3", got)
+	}
+
+	doN(t, DefaultMinRequests, failRun)
+	assertStatus(t, b, StatusOpen)
+	clock.Advance(101 * time.Millisecond)
+	doN(t, 3, successRun)
+	assertStatus(t, b, StatusClosed)
+}
+
+func TestBreakerIgnoreErrors(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo().SetIgnoreErrors(sql.ErrNoRows))
+
+	doN(t, 30, func() error {
+		return sql.ErrNoRows
+	})
+	assertStatus(t, b, StatusClosed)
+}
+
+func TestBreakerPanicCountsAsFailure(t *testing.T) {
+	//注册的判定函数经Do生效，但不影响panic的计数
+	setting := NewBreakSettingInfo().SetIsFailure(func(err error) bool {
+		return false
+	}).SetIgnoreErrors(PanicError)
+	b, _ := newTestBreaker(t, setting)
+
+	doN(t, DefaultMinRequests/2, failRun)
+	assertStatus(t, b, StatusClosed)
+
+	for i := 0; i < DefaultMinRequests/2; i++ {
+		runErr := Do(context.Background(), t.Name(), func() error {
+			panic("boom")
+		}, nil)
+		if !errors.Is(runErr, PanicError) {
+			t.Fatalf("Do() error = %v, want PanicError", runErr)
+		}
+	}
+	assertStatus(t, b, StatusOpen)
+}
+
+func TestBreakerSlowCalls(t *testing.T) {
+	setting := NewBreakSettingInfo().SetSlowCall(100*time.Millisecond, 50)
+	b, clock := newTestBreaker(t, setting)
+
+	doN(t, 20, func() error {
+		clock.Advance(200 * time.Millisecond)
+		return nil
+	})
+	assertStatus(t, b, StatusOpen)
+}
+
+func TestBreakerConsecutiveFailuresStrategy(t *testing.T) {
+	setting := NewBreakSettingInfo().SetTripStrategy(NewConsecutiveFailuresStrategy(3))
+	b, _ := newTestBreaker(t, setting)
+
+	doN(t, 2, failRun)
+	doN(t, 1, successRun)
+	doN(t, 2, failRun)
+	assertStatus(t, b, StatusClosed)
+	doN(t, 1, failRun)
+	assertStatus(t, b, StatusOpen)
+}
+
+func TestWindowExpiresOldGrids(t *testing.T) {
+	b, clock := newTestBreaker(t, NewBreakSettingInfo())
+
+	doN(t, 9, failRun)
+	clock.Advance(11 * time.Second)
+	doN(t, 9, successRun)
+	doN(t, 1, failRun)
+	assertStatus(t, b, StatusClosed)
+}
+
+func TestManagerResetAndEviction(t *testing.T) {
+	b, clock := newTestBreaker(t, NewBreakSettingInfo())
+
+	doN(t, 20, failRun)
+	assertStatus(t, b, StatusOpen)
+	if !Reset(t.Name()) {
+		t.Fatal("Reset() = false")
+	}
+	if !b.IsClosed() {
+		t.Fatal("old breaker not closed after Reset")
+	}
+	reset, _ := getBreakerManager(t.Name())
+	assertStatus(t, reset, StatusClosed)
+	if reset.clock != clock {
+		t.Fatal("Reset() did not keep settings")
+	}
+
+	//已关闭的熔断器不再计数，调用转到管理器中当前的熔断器
+	before := b.counter.GetWindowStat()
+	b.report(errDependency, 0)
+	if got := b.counter.GetWindowStat(); got != before {
+		t.Fatalf("closed breaker window = %+v, want %+v", got, before)
+	}
+	if err := b.do(context.Background(), func() (time.Duration, error) {
+		return 0, errDependency
+	}, nil); !errors.Is(err, errDependency) {
+		t.Fatalf("closed breaker do() error = %v, want errDependency", err)
+	}
+	if got := reset.counter.GetWindowStat().Failed; got != 1 {
+		t.Fatalf("current breaker failed = %d, want 1", got)
+	}
+
+	//代码注册的熔断器空闲时不淘汰，由默认值创建的熔断器空闲时淘汰
+	idleName := t.Name() + "/idle"
+	idle, _ := getBreakerManager(idleName)
+	atomic.StoreInt64(&idle.lastUsed, 0)
+	clock.Advance(time.Minute)
+	if got := bm.evictIdle(30 * time.Second); got != 1 {
+		t.Fatalf("evictIdle() = %d, want 1", got)
+	}
+	if !idle.IsClosed() || reset.IsClosed() {
+		t.Fatalf("closed = %v/%v, want only the default breaker evicted", idle.IsClosed(), reset.IsClosed())
+	}
+	if Remove(idleName) {
+		t.Fatal("Remove() = true after eviction")
+	}
+}
+
+func TestDoAfterClose(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo())
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	//关闭后从管理器中删除，Do按配置创建新的熔断器
+	if errs := doN(t, 1, failRun); len(errs) != 1 || !errors.Is(errs[0], errDependency) {
+		t.Fatalf("fallback errors = %v, want [errDependency]", errs)
+	}
+	current, _ := getBreakerManager(t.Name())
+	if current == b || current.IsClosed() {
+		t.Fatal("Do() after Close used the closed breaker")
+	}
+	if stat := current.counter.GetWindowStat(); stat.Total != 1 {
+		t.Fatalf("current window stat = %+v, want total 1", stat)
+	}
+
+	//已关闭的熔断器仍在管理器中时直接返回ClosedError，不会递归
+	bm.mutex.Lock()
+	bm.manager[t.Name()] = b
+	bm.mutex.Unlock()
+	var fallbackErr error
+	err := b.do(context.Background(), func() (time.Duration, error) {
+		t.Fatal("closed breaker executed the call")
+		return 0, nil
+	}, func(err error) {
+		fallbackErr = err
+	})
+	if !errors.Is(err, ClosedError) || !errors.Is(fallbackErr, ClosedError) {
+		t.Fatalf("do() error = %v, fallback error = %v, want ClosedError", err, fallbackErr)
+	}
+}
+
+func TestBreakerAdaptiveThrottling(t *testing.T) {
+	setting := NewBreakSettingInfo().SetAdaptive(2).SetRandom(func() float64 {
+		return 0.5
+	})
+	b, clock := newTestBreaker(t, setting)
+
+	//拒绝概率为n/(n+1)，前两次请求概率不超过0.5时放行，之后全部拒绝
+	errs := doN(t, 200, failRun)
+	rejected := 0
+	for _, err := range errs {
+		if errors.Is(err, OpenError) {
+			rejected++
+		}
+	}
+	if rejected != 198 {
+		t.Fatalf("rejected = %d, want 198", rejected)
+	}
+	assertStatus(t, b, StatusClosed)
+	//拒绝的请求单独计数，不计为失败，也不计入耗时直方图
+	stat := b.counter.GetWindowStat()
+	if stat.Total != 2 || stat.Failed != 2 || stat.Rejected != 198 || stat.ConsecutiveFailures != 2 {
+		t.Fatalf("window stat = %+v, want total 2, failed 2, rejected 198, consecutive failures 2", stat)
+	}
+	if _, count := b.counter.histogram(); count != 2 {
+		t.Fatalf("histogram count = %d, want 2", count)
+	}
+
+	clock.Advance(11 * time.Second)
+	if got := b.rejectProbability(); got != 0 {
+		t.Fatalf("rejectProbability() = %v after window, want 0", got)
+	}
+	if errs := doN(t, 1, successRun); len(errs) != 0 {
+		t.Fatalf("fallback errors after window = %v", errs)
+	}
+}
+
+func TestBreakerBulkheadFull(t *testing.T) {
+	newTestBreaker(t, NewBreakSettingInfo().SetBulkhead(2, 0, 0))
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	done := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_ = Do(context.Background(), t.Name(), func() error {
+				started <- struct{}{}
+				<-release
+				return nil
+			}, nil)
+			done <- struct{}{}
+		}()
+	}
+	<-started
+	<-started
+
+	errs := doN(t, 1, successRun)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrBulkheadFull) {
+		t.Fatalf("fallback errors = %v, want [ErrBulkheadFull]", errs)
+	}
+	close(release)
+	<-done
+	<-done
+	if errs := doN(t, 1, successRun); len(errs) != 0 {
+		t.Fatalf("fallback errors after release = %v", errs)
+	}
+}
+
+func TestBreakerBulkheadQueueTimeout(t *testing.T) {
+	_, clock := newTestBreaker(t, NewBreakSettingInfo().SetBulkhead(1, 1, time.Second))
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		_ = Do(context.Background(), t.Name(), func() error {
+			<-release
+			return nil
+		}, nil)
+		close(done)
+	}()
+	//在协程中调用并返回fallback收到的错误，放行时为nil
+	queue := func() <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			var fallbackErr error
+			_ = Do(context.Background(), t.Name(), successRun, func(err error) {
+				fallbackErr = err
+			})
+			result <- fallbackErr
+		}()
+		return result
+	}
+	waitQueued := func(n int) {
+		t.Helper()
+		b, _ := getBreakerManager(t.Name())
+		deadline := time.Now().Add(time.Second)
+		for b.bulkhead.InFlight() != 1 || b.bulkhead.Queued() != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("in flight = %d, queued = %d, want 1, %d", b.bulkhead.InFlight(), b.bulkhead.Queued(), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitQueued(0)
+
+	//排队已满时立即拒绝
+	queued := queue()
+	waitQueued(1)
+	waitTimers(t, clock, 1)
+	if errs := doN(t, 1, successRun); len(errs) != 1 || !errors.Is(errs[0], ErrBulkheadFull) {
+		t.Fatalf("fallback errors = %v, want [ErrBulkheadFull]", errs)
+	}
+
+	//按注入的时钟排队超时
+	clock.Advance(time.Second - time.Millisecond)
+	select {
+	case err := <-queued:
+		t.Fatalf("queued call returned %v before the timeout", err)
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	if err := waitResult(t, queued); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("queued fallback error = %v, want ErrBulkheadFull", err)
+	}
+
+	//超时前释放时排队的请求获得槽位
+	queued = queue()
+	waitTimers(t, clock, 1)
+	close(release)
+	<-done
+	if err := waitResult(t, queued); err != nil {
+		t.Fatalf("queued fallback error = %v, want nil", err)
+	}
+	waitTimers(t, clock, 0)
+}
+
+func TestDoWithRetryCountsOnce(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo())
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	attempts := 0
+	err := DoWithRetry(context.Background(), t.Name(), policy, func() error {
+		attempts++
+		if attempts < 3 {
+			return errDependency
+		}
+		return nil
+	}, nil)
+	if err != nil || attempts != 3 {
+		t.Fatalf("DoWithRetry() error = %v, attempts = %d", err, attempts)
+	}
+	if stat := b.counter.GetWindowStat(); stat.Total != 1 || stat.Failed != 0 {
+		t.Fatalf("window stat = %+v, want one success", stat)
+	}
+}
+
+func TestDoWithRetryStopsWhenOpen(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo())
+	policy := &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond}
+
+	attempts := 0
+	_ = DoWithRetry(context.Background(), t.Name(), policy, func() error {
+		attempts++
+		//其他请求在重试期间触发熔断
+		ForceOpen(t.Name())
+		return errDependency
+	}, nil)
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+	if got := b.counter.GetWindowStat().Failed; got != 1 {
+		t.Fatalf("window failed = %d, want 1", got)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	budget := NewRetryBudget(20, 0).SetClock(clock)
+	for i := 0; i < 10; i++ {
+		budget.deposit()
+	}
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if budget.withdraw() {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("allowed retries = %d, want 2", allowed)
+	}
+}
+
+func TestDoHedgedTakesFirstSuccess(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo())
+	policy := &HedgePolicy{Delay: 5 * time.Millisecond}
+
+	var calls int32
+	cancelled := make(chan struct{}, 1)
+	err := DoHedged(context.Background(), t.Name(), policy, func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			//第一次请求卡住，直到被取消
+			<-ctx.Done()
+			cancelled <- struct{}{}
+			return ctx.Err()
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("DoHedged() error = %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("primary attempt not cancelled")
+	}
+	if stat := b.counter.GetWindowStat(); stat.Total != 1 || stat.Failed != 0 {
+		t.Fatalf("window stat = %+v, want one success", stat)
+	}
+}
+
+func TestApplyConfigKeepsWindowState(t *testing.T) {
+	name := t.Name()
+	t.Cleanup(func() {
+		_ = ApplyConfig(Config{})
+		Remove(name)
+	})
+	config := Config{
+		Default:  BreakerConfig{Interval: 10 * time.Second, GridNum: 10, MinRequests: 5},
+		Breakers: []BreakerConfig{{Name: name, ErrorPercentThreshold: 60}},
+	}
+	if err := ApplyConfig(config); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+	doN(t, 4, failRun)
+
+	//只修改阈值时保留窗口统计，再失败一次即熔断
+	config.Breakers[0].ErrorPercentThreshold = 80
+	if err := ApplyConfig(config); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+	b, _ := getBreakerManager(name)
+	if b.setting.ErrorPercentThreshold != 80 {
+		t.Fatalf("ErrorPercentThreshold = %d, want 80", b.setting.ErrorPercentThreshold)
+	}
+	doN(t, 1, failRun)
+	assertStatus(t, b, StatusOpen)
+
+	//窗口格子数变化时重建窗口
+	config.Default.GridNum = 20
+	if err := ApplyConfig(config); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+	b, _ = getBreakerManager(name)
+	assertStatus(t, b, StatusClosed)
+
+	//非法配置不生效
+	config.Default.GridNum = 3
+	if err := ApplyConfig(config); err == nil {
+		t.Fatal("ApplyConfig() error = nil, want interval error")
+	}
+	if b2, _ := getBreakerManager(name); b2 != b {
+		t.Fatal("breaker replaced by invalid config")
+	}
+}
+
+func TestRegisterValidatesAndRefusesDuplicates(t *testing.T) {
+	name := t.Name()
+	t.Cleanup(func() {
+		Remove(name)
+	})
+	invalid := [][]Option{
+		{WithErrorPercentThreshold(120)},
+		{WithBreakerErrorPercentThreshold(-1)},
+		{WithSleepWindow(-time.Second)},
+		{WithWindow(10*time.Second, 3)},
+		{WithBulkhead(0, 5, 0)},
+	}
+	for _, opts := range invalid {
+		if _, err := Register(name, opts...); !errors.Is(err, InvalidSettingError) {
+			t.Fatalf("Register() error = %v, want InvalidSettingError", err)
+		}
+	}
+
+	b, err := Register(name, WithBreakerErrorPercentThreshold(30), WithBreakerTestMax(5))
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if b.setting.BreakerErrorPercentThreshold != 30 || b.setting.BreakerTestMax != 5 {
+		t.Fatalf("setting = %+v, want half-open threshold 30 and test max 5", b.setting.key())
+	}
+	//配置相同时返回已有熔断器
+	if same, err := Register(name, WithBreakerErrorPercentThreshold(30), WithBreakerTestMax(5)); err != nil || same != b {
+		t.Fatalf("Register() = %p, %v, want existing breaker", same, err)
+	}
+	if _, err := Register(name, WithBreakerErrorPercentThreshold(40)); !errors.Is(err, DuplicateNameError) {
+		t.Fatalf("Register() error = %v, want DuplicateNameError", err)
+	}
+}
+
+func TestRegisterComparesStrategyAndErrors(t *testing.T) {
+	name := t.Name()
+	t.Cleanup(func() {
+		Remove(name)
+	})
+	ignored := errors.New("ignored")
+	b, err := Register(name, WithTripStrategy(NewConsecutiveFailuresStrategy(5)), WithIgnoreErrors(ignored))
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	//熔断策略按值比较
+	if same, err := Register(name, WithTripStrategy(NewConsecutiveFailuresStrategy(5)), WithIgnoreErrors(ignored)); err != nil || same != b {
+		t.Fatalf("Register() = %p, %v, want existing breaker", same, err)
+	}
+
+	different := [][]Option{
+		{WithTripStrategy(NewConsecutiveFailuresStrategy(6)), WithIgnoreErrors(ignored)},
+		{WithTripStrategy(NewFailureCountStrategy(5)), WithIgnoreErrors(ignored)},
+		{WithIgnoreErrors(ignored)},
+		{WithTripStrategy(NewConsecutiveFailuresStrategy(5))},
+		//内容相同的另一个错误实例
+		{WithTripStrategy(NewConsecutiveFailuresStrategy(5)), WithIgnoreErrors(errors.New("ignored"))},
+		//函数字段无法比较
+		{WithTripStrategy(NewConsecutiveFailuresStrategy(5)), WithIgnoreErrors(ignored), WithIsFailure(func(error) bool {
+			return true
+		})},
+		{WithTripStrategy(NewConsecutiveFailuresStrategy(5)), WithIgnoreErrors(ignored), WithRandom(func() float64 {
+			return 0
+		})},
+	}
+	for idx, opts := range different {
+		if _, err := Register(name, opts...); !errors.Is(err, DuplicateNameError) {
+			t.Fatalf("case %d: Register() error = %v, want DuplicateNameError", idx, err)
+		}
+	}
+
+	//已注册的熔断器设置了函数字段时，相同的配置也不能重复注册
+	funcName := name + "/func"
+	t.Cleanup(func() {
+		Remove(funcName)
+	})
+	isFailure := func(error) bool {
+		return true
+	}
+	if _, err := Register(funcName, WithIsFailure(isFailure)); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := Register(funcName, WithIsFailure(isFailure)); !errors.Is(err, DuplicateNameError) {
+		t.Fatalf("Register() error = %v, want DuplicateNameError", err)
+	}
+	if _, err := Register(funcName); !errors.Is(err, DuplicateNameError) {
+		t.Fatalf("Register() without IsFailure error = %v, want DuplicateNameError", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	b, clock := newTestBreaker(t, NewBreakSettingInfo())
+	snapshotter := NewSnapshotter(SnapshotSetting{Path: t.TempDir() + "/breaker.json", Clock: clock})
+	doN(t, 20, failRun)
+	assertStatus(t, b, StatusOpen)
+	saved := b.counter.GetWindowStat()
+	if err := snapshotter.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	//重置后恢复为打开状态及原窗口计数
+	Reset(t.Name())
+	b, _ = getBreakerManager(t.Name())
+	assertStatus(t, b, StatusClosed)
+	if n, err := snapshotter.Restore(); err != nil || n == 0 {
+		t.Fatalf("Restore() = %d, %v, want restored breakers", n, err)
+	}
+	assertStatus(t, b, StatusOpen)
+	if stat := b.counter.GetWindowStat(); stat != saved {
+		t.Fatalf("window = %+v, want %+v", stat, saved)
+	}
+
+	//快照过期后不再恢复
+	Reset(t.Name())
+	clock.Advance(DefaultSnapshotMaxAge + time.Second)
+	if _, err := snapshotter.Restore(); !errors.Is(err, SnapshotStaleError) {
+		t.Fatalf("Restore() error = %v, want SnapshotStaleError", err)
+	}
+
+	//时钟靠近Unix 0时窗口内包含序号为-1的空格子，恢复时跳过
+	clock.Set(time.Unix(1, 0))
+	Reset(t.Name())
+	b, _ = getBreakerManager(t.Name())
+	doN(t, 2, failRun)
+	saved = b.counter.GetWindowStat()
+	if err := snapshotter.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	negative := false
+	for _, grid := range b.snapshot().Grids {
+		negative = negative || grid.Epoch < 0
+	}
+	if !negative {
+		t.Fatal("snapshot near Unix 0 has no grid with a negative epoch")
+	}
+	Reset(t.Name())
+	b, _ = getBreakerManager(t.Name())
+	if n, err := snapshotter.Restore(); err != nil || n == 0 {
+		t.Fatalf("Restore() = %d, %v, want restored breakers", n, err)
+	}
+	if stat := b.counter.GetWindowStat(); stat != saved {
+		t.Fatalf("window = %+v, want %+v", stat, saved)
+	}
+}
+
+func TestHedgePercentileDelay(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo())
+	policy := &HedgePolicy{Delay: time.Second, Percentile: 0.95}
+	if got := policy.delay(b); got != time.Second {
+		t.Fatalf("delay without samples = %v, want Delay", got)
+	}
+	for i := 0; i < 20; i++ {
+		b.counter.AddForClose(true, 20*time.Millisecond)
+	}
+	if got := policy.delay(b); got < 16*time.Millisecond || got > 24*time.Millisecond {
+		t.Fatalf("delay = %v, want ~20ms p95", got)
+	}
+	if stat, ok := Latency(t.Name()); !ok || stat.Count != 20 {
+		t.Fatalf("Latency() = %+v, %v, want 20 samples", stat, ok)
+	}
+}
+
+func TestDoHedgedSkipsNonPositiveDelay(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo())
+	policies := []*HedgePolicy{
+		//窗口内还没有耗时统计，需在其他调用计入窗口之前执行
+		{Percentile: 0.95},
+		{},
+		{Delay: -time.Millisecond},
+		{Delay: time.Second, DelayFunc: func() time.Duration {
+			return 0
+		}},
+	}
+	for idx, policy := range policies {
+		var calls int32
+		err := DoHedged(context.Background(), t.Name(), policy, func(ctx context.Context) error {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatalf("case %d: DoHedged() error = %v", idx, err)
+		}
+		if got := atomic.LoadInt32(&calls); got != 1 {
+			t.Fatalf("case %d: calls = %d, want 1", idx, got)
+		}
+	}
+	if hedges := atomic.LoadUint64(&b.metrics.hedges); hedges != 0 {
+		t.Fatalf("hedges = %d, want 0", hedges)
+	}
+}
@@ -0,0 +1,83 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+//ErrBulkheadFull 并发数已满且排队已满或排队超时
+var ErrBulkheadFull = errors.New("bulkhead full")
+
+//舱壁隔离，限制同一策略同时执行的请求数
+type bulkhead struct {
+	//执行槽位，容量为最大并发数
+	slots chan struct{}
+
+	//排队中的请求数
+	queued int32
+
+	//最大排队数
+	maxQueue int32
+
+	//排队超时时间，为0时一直等待到ctx结束
+	queueTimeout time.Duration
+
+	//时钟，排队超时按该时钟计时
+	clock Clock
+}
+
+//创建舱壁隔离，maxConcurrent为最大并发数，maxQueue为最大排队数
+func newBulkhead(maxConcurrent int, maxQueue int, queueTimeout time.Duration, clock Clock) *bulkhead {
+	return &bulkhead{
+		slots:        make(chan struct{}, maxConcurrent),
+		maxQueue:     int32(maxQueue),
+		queueTimeout: queueTimeout,
+		clock:        clock,
+	}
+}
+
+//获取执行槽位，并发已满时排队等待，获取失败返回false
+func (bulkhead *bulkhead) acquire(ctx context.Context) bool {
+	select {
+	case bulkhead.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if atomic.AddInt32(&bulkhead.queued, 1) > bulkhead.maxQueue {
+		atomic.AddInt32(&bulkhead.queued, -1)
+		return false
+	}
+	defer atomic.AddInt32(&bulkhead.queued, -1)
+
+	var timeout <-chan time.Time
+	if bulkhead.queueTimeout > 0 {
+		timer, stop := newClockTimer(bulkhead.clock, bulkhead.queueTimeout)
+		defer stop()
+		timeout = timer
+	}
+	select {
+	case bulkhead.slots <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//释放执行槽位
+func (bulkhead *bulkhead) release() {
+	<-bulkhead.slots
+}
+
+//InFlight 返回正在执行的请求数
+func (bulkhead *bulkhead) InFlight() int {
+	return len(bulkhead.slots)
+}
+
+//Queued 返回排队中的请求数
+func (bulkhead *bulkhead) Queued() int {
+	return int(atomic.LoadInt32(&bulkhead.queued))
+}
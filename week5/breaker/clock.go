@@ -0,0 +1,134 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+//Clock 时钟，熔断器和滑动窗口通过它获取当前时间，测试时可替换为ManualClock
+type Clock interface {
+	Now() time.Time
+}
+
+//系统时钟
+type systemClock struct{}
+
+//Now 返回系统当前时间
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+//NewTimer 返回系统定时器的通道及停止函数
+func (systemClock) NewTimer(duration time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(duration)
+	return timer.C, timer.Stop
+}
+
+//TimerClock 支持定时器的时钟，限流器等待时使用，ManualClock推进时间时触发到期的定时器
+type TimerClock interface {
+	Clock
+
+	//NewTimer 返回经过duration后触发的通道及停止函数
+	NewTimer(duration time.Duration) (<-chan time.Time, func() bool)
+}
+
+//按时钟创建定时器，时钟不支持定时器时使用系统定时器
+func newClockTimer(clock Clock, duration time.Duration) (<-chan time.Time, func() bool) {
+	if timerClock, ok := clock.(TimerClock); ok {
+		return timerClock.NewTimer(duration)
+	}
+	timer := time.NewTimer(duration)
+	return timer.C, timer.Stop
+}
+
+//DefaultClock 默认使用系统时钟
+var DefaultClock Clock = systemClock{}
+
+//ManualClock 手动时钟，只有调用Advance或Set时时间才会变化
+type ManualClock struct {
+	//读写锁
+	mutex sync.RWMutex
+
+	//当前时间
+	now time.Time
+
+	//等待触发的定时器
+	timers []*manualTimer
+}
+
+//手动时钟上的定时器
+type manualTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+//NewManualClock 创建一个从now开始的手动时钟
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+//Now 返回手动时钟的当前时间
+func (manualClock *ManualClock) Now() time.Time {
+	manualClock.mutex.RLock()
+	defer manualClock.mutex.RUnlock()
+	return manualClock.now
+}
+
+//Advance 将时钟向前推进duration
+func (manualClock *ManualClock) Advance(duration time.Duration) {
+	manualClock.mutex.Lock()
+	defer manualClock.mutex.Unlock()
+	manualClock.now = manualClock.now.Add(duration)
+	manualClock.fire()
+}
+
+//Set 将时钟设置为指定时间
+func (manualClock *ManualClock) Set(now time.Time) {
+	manualClock.mutex.Lock()
+	defer manualClock.mutex.Unlock()
+	manualClock.now = now
+	manualClock.fire()
+}
+
+//NewTimer 返回时钟推进到当前时间加duration时触发的通道及停止函数
+func (manualClock *ManualClock) NewTimer(duration time.Duration) (<-chan time.Time, func() bool) {
+	manualClock.mutex.Lock()
+	defer manualClock.mutex.Unlock()
+	timer := &manualTimer{deadline: manualClock.now.Add(duration), ch: make(chan time.Time, 1)}
+	if duration <= 0 {
+		timer.ch <- manualClock.now
+		return timer.ch, func() bool {
+			return false
+		}
+	}
+	manualClock.timers = append(manualClock.timers, timer)
+	return timer.ch, func() bool {
+		return manualClock.stop(timer)
+	}
+}
+
+//触发已到期的定时器，调用方需持有写锁
+func (manualClock *ManualClock) fire() {
+	pending := manualClock.timers[:0]
+	for _, timer := range manualClock.timers {
+		if timer.deadline.After(manualClock.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.ch <- manualClock.now
+	}
+	manualClock.timers = pending
+}
+
+//停止定时器，定时器未触发时返回true
+func (manualClock *ManualClock) stop(timer *manualTimer) bool {
+	manualClock.mutex.Lock()
+	defer manualClock.mutex.Unlock()
+	for idx, pending := range manualClock.timers {
+		if pending == timer {
+			manualClock.timers = append(manualClock.timers[:idx], manualClock.timers[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package breaker
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+//BreakerConfig 配置文件中单个熔断器的配置，零值字段使用默认值，时间字段支持"10s"、"500ms"等格式
+type BreakerConfig struct {
+	//策略名，Default中忽略
+	Name                         string
+	Interval                     time.Duration
+	GridNum                      int
+	SleepWindow                  time.Duration
+	BreakerTestMax               int
+	ErrorPercentThreshold        int
+	BreakerErrorPercentThreshold int
+	MinRequests                  int
+	SlowCallDuration             time.Duration
+	SlowCallPercent              int
+	AdaptiveK                    float64
+	MaxConcurrent                int
+	MaxQueue                     int
+	QueueTimeout                 time.Duration
+}
+
+//Config 熔断器配置段，Breakers中未配置的字段使用Default，未出现在Breakers中的策略使用Default
+//viper会将map的key转为小写，所以Breakers使用列表而不是以策略名为key
+type Config struct {
+	Default  BreakerConfig
+	Breakers []BreakerConfig
+}
+
+//将非零值字段写入熔断器配置
+func (config BreakerConfig) apply(setting *breakSettingInfo) {
+	if config.Interval > 0 {
+		setting.Interval = config.Interval
+	}
+	if config.GridNum > 0 {
+		setting.GridNum = config.GridNum
+	}
+	if config.SleepWindow > 0 {
+		setting.SleepWindow = config.SleepWindow
+	}
+	if config.BreakerTestMax > 0 {
+		setting.BreakerTestMax = config.BreakerTestMax
+	}
+	if config.ErrorPercentThreshold > 0 {
+		setting.ErrorPercentThreshold = config.ErrorPercentThreshold
+	}
+	if config.BreakerErrorPercentThreshold > 0 {
+		setting.BreakerErrorPercentThreshold = config.BreakerErrorPercentThreshold
+	}
+	if config.MinRequests > 0 {
+		setting.MinRequests = config.MinRequests
+	}
+	if config.SlowCallDuration > 0 {
+		setting.SlowCallDuration = config.SlowCallDuration
+	}
+	if config.SlowCallPercent > 0 {
+		setting.SlowCallPercent = config.SlowCallPercent
+	}
+	if config.AdaptiveK > 0 {
+		setting.AdaptiveK = config.AdaptiveK
+	}
+	if config.MaxConcurrent > 0 {
+		setting.MaxConcurrent = config.MaxConcurrent
+		setting.MaxQueue = config.MaxQueue
+		setting.QueueTimeout = config.QueueTimeout
+	}
+}
+
+//按配置生成指定策略名的熔断器配置，config为空时使用默认值
+func (config *Config) settingFor(name string) *breakSettingInfo {
+	setting := NewBreakSettingInfo().SetName(name)
+	if config == nil {
+		return setting
+	}
+	config.Default.apply(setting)
+	for _, breakerConfig := range config.Breakers {
+		if breakerConfig.Name == name {
+			breakerConfig.apply(setting)
+		}
+	}
+	return setting
+}
+
+//校验配置，默认配置及每个策略的配置都需要能创建熔断器
+func (config *Config) validate() error {
+	if _, err := config.settingFor("default").build(); err != nil {
+		return fmt.Errorf("breaker config default: %w", err)
+	}
+	for _, breakerConfig := range config.Breakers {
+		if breakerConfig.Name == "" {
+			return fmt.Errorf("breaker config: %w", NameNilError)
+		}
+		if _, err := config.settingFor(breakerConfig.Name).build(); err != nil {
+			return fmt.Errorf("breaker config %s: %w", breakerConfig.Name, err)
+		}
+	}
+	return nil
+}
+
+//继承旧熔断器的运行状态，窗口周期及格子数不变时保留窗口统计、熔断状态和休眠时间
+func (broker *breaker) inherit(old *breaker) {
+	atomic.StoreInt64(&broker.lastUsed, atomic.LoadInt64(&old.lastUsed))
+	atomic.StoreInt32(&broker.forced, atomic.LoadInt32(&old.forced))
+	broker.metrics.inherit(&old.metrics)
+	if broker.setting.Interval != old.setting.Interval || broker.setting.GridNum != old.setting.GridNum ||
+		(broker.adaptiveK > 0) != (old.adaptiveK > 0) {
+		return
+	}
+	broker.counter.inherit(old.counter)
+	atomic.StoreInt64(&broker.cycleTime, atomic.LoadInt64(&old.cycleTime))
+}
+
+//继承旧窗口的格子及状态，格子环只包含原子操作，新旧熔断器可同时使用
+func (slidingWindow *SlidingWindow) inherit(old *SlidingWindow) {
+	slidingWindow.ring = old.ring
+	atomic.StoreInt32(&slidingWindow.halfOpenReqNum, atomic.LoadInt32(&old.halfOpenReqNum))
+	atomic.StoreInt32(&slidingWindow.halfOpenFailReqNum, atomic.LoadInt32(&old.halfOpenFailReqNum))
+	atomic.StoreUint32(&slidingWindow.consecutiveFailures, atomic.LoadUint32(&old.consecutiveFailures))
+	atomic.StoreInt32(&slidingWindow.status, atomic.LoadInt32(&old.status))
+}
+
+//继承累计指标
+func (metrics *breakerMetrics) inherit(old *breakerMetrics) {
+	atomic.StoreUint64(&metrics.requests, atomic.LoadUint64(&old.requests))
+	atomic.StoreUint64(&metrics.failures, atomic.LoadUint64(&old.failures))
+	atomic.StoreUint64(&metrics.rejected, atomic.LoadUint64(&old.rejected))
+	atomic.StoreUint64(&metrics.probes, atomic.LoadUint64(&old.probes))
+	atomic.StoreUint64(&metrics.bulkheadRejected, atomic.LoadUint64(&old.bulkheadRejected))
+	atomic.StoreUint64(&metrics.retries, atomic.LoadUint64(&old.retries))
+	atomic.StoreUint64(&metrics.hedges, atomic.LoadUint64(&old.hedges))
+}
+
+//按新配置重建由配置创建的熔断器，代码中设置的熔断策略、时钟、失败判定及随机数函数保持不变
+func (breakerManager *breakerManager) applyConfig(config *Config) {
+	var replaced []*breaker
+	breakerManager.mutex.Lock()
+	breakerManager.config = config
+	for name, old := range breakerManager.manager {
+		if !old.configured {
+			continue
+		}
+		setting := config.settingFor(name)
+		setting.TripStrategy = old.setting.TripStrategy
+		setting.Clock = old.setting.Clock
+		setting.IsFailure = old.setting.IsFailure
+		setting.IgnoreErrors = old.setting.IgnoreErrors
+		setting.Random = old.setting.Random
+		broker, err := setting.build()
+		if err != nil {
+			continue
+		}
+		broker.configured = true
+		broker.inherit(old)
+		breakerManager.manager[name] = broker
+		replaced = append(replaced, old)
+	}
+	breakerManager.mutex.Unlock()
+	for _, old := range replaced {
+		_ = old.Close()
+	}
+}
+
+//ApplyConfig 应用熔断器配置，之后自动创建的熔断器使用该配置
+//已由配置或默认值创建的熔断器立即按新配置重建，窗口周期及格子数不变时保留当前窗口统计和熔断状态
+//配置校验失败时返回错误且不做任何修改，可在配置文件变化时重复调用实现热更新
+func ApplyConfig(config Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	config.Breakers = append([]BreakerConfig(nil), config.Breakers...)
+	bm.applyConfig(&config)
+	return nil
+}
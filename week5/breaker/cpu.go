@@ -0,0 +1,231 @@
+package breaker
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//CPU采样间隔
+	cpuSampleInterval = 500 * time.Millisecond
+
+	//CPU使用率滑动平均的衰减系数
+	cpuDecay = 0.95
+)
+
+var (
+	//CPU使用率千分比，滑动平均后的值
+	cpuUsage int64
+
+	//保证采样协程只启动一次
+	cpuSamplerOnce sync.Once
+)
+
+//CPU时间
+type cpuTimes struct {
+	//总时间
+	total uint64
+
+	//空闲时间
+	idle uint64
+}
+
+//从/proc/stat读取CPU时间
+func readCPUTimes() (cpuTimes, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		var times cpuTimes
+		//user nice system idle iowait irq softirq steal，guest已计入user
+		for idx, field := range fields[1:] {
+			if idx >= 8 {
+				break
+			}
+			val, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return cpuTimes{}, err
+			}
+			times.total += val
+			if idx == 3 || idx == 4 {
+				times.idle += val
+			}
+		}
+		return times, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return cpuTimes{}, err
+	}
+	return cpuTimes{}, errors.New("cpu line not found in /proc/stat")
+}
+
+//cgroup根目录
+const cgroupRoot = "/sys/fs/cgroup"
+
+//cgroup中的CPU用量及配额
+type cgroupCPU struct {
+	//累计使用的CPU时间
+	usage time.Duration
+
+	//可用的CPU核数，未设置配额时为主机核数
+	limit float64
+}
+
+//读取文件中的第一个整数
+func readCgroupInt(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+//读取cgroup v2的CPU用量及配额，cpu.stat中usage_usec为累计用量，cpu.max为"配额 周期"，配额为max时不限制
+func readCgroupV2CPU(root string) (cgroupCPU, error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, "cpu.stat"))
+	if err != nil {
+		return cgroupCPU{}, err
+	}
+	cpu := cgroupCPU{limit: float64(runtime.NumCPU())}
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return cgroupCPU{}, err
+			}
+			cpu.usage = time.Duration(usec) * time.Microsecond
+			found = true
+		}
+	}
+	if !found {
+		return cgroupCPU{}, errors.New("usage_usec not found in cpu.stat")
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, quotaErr := strconv.ParseFloat(fields[0], 64)
+			period, periodErr := strconv.ParseFloat(fields[1], 64)
+			if quotaErr == nil && periodErr == nil && quota > 0 && period > 0 {
+				cpu.limit = quota / period
+			}
+		}
+	}
+	return cpu, nil
+}
+
+//读取cgroup v1的CPU用量及配额，cpuacct.usage单位为纳秒，cfs_quota_us为-1时不限制
+func readCgroupV1CPU(root string) (cgroupCPU, error) {
+	var usage int64
+	var err error
+	for _, dir := range []string{"cpuacct", "cpu,cpuacct"} {
+		usage, err = readCgroupInt(filepath.Join(root, dir, "cpuacct.usage"))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return cgroupCPU{}, err
+	}
+	cpu := cgroupCPU{usage: time.Duration(usage), limit: float64(runtime.NumCPU())}
+	for _, dir := range []string{"cpu", "cpu,cpuacct"} {
+		quota, quotaErr := readCgroupInt(filepath.Join(root, dir, "cpu.cfs_quota_us"))
+		period, periodErr := readCgroupInt(filepath.Join(root, dir, "cpu.cfs_period_us"))
+		if quotaErr == nil && periodErr == nil {
+			if quota > 0 && period > 0 {
+				cpu.limit = float64(quota) / float64(period)
+			}
+			break
+		}
+	}
+	return cpu, nil
+}
+
+//读取cgroup的CPU用量，依次尝试cgroup v2及v1
+func readCgroupCPU(root string) (cgroupCPU, error) {
+	if cpu, err := readCgroupV2CPU(root); err == nil {
+		return cpu, nil
+	}
+	return readCgroupV1CPU(root)
+}
+
+//返回CPU采样函数，每次调用返回与上次调用之间的CPU使用率千分比
+//优先使用cgroup，容器中按容器的CPU配额计算，读取不到cgroup时使用/proc/stat中主机的CPU使用率
+func newCPUSampler(root string) (func() (float64, bool), error) {
+	if prev, err := readCgroupCPU(root); err == nil {
+		prevTime := time.Now()
+		return func() (float64, bool) {
+			cur, err := readCgroupCPU(root)
+			now := time.Now()
+			elapsed := now.Sub(prevTime)
+			if err != nil || elapsed <= 0 || cur.limit <= 0 {
+				return 0, false
+			}
+			usage := float64(cur.usage-prev.usage) * 1000 / (float64(elapsed) * cur.limit)
+			prev, prevTime = cur, now
+			if usage > 1000 {
+				usage = 1000
+			}
+			return usage, true
+		}, nil
+	}
+	prev, err := readCPUTimes()
+	if err != nil {
+		return nil, err
+	}
+	return func() (float64, bool) {
+		cur, err := readCPUTimes()
+		if err != nil || cur.total <= prev.total {
+			return 0, false
+		}
+		usage := 1000 - float64(cur.idle-prev.idle)*1000/float64(cur.total-prev.total)
+		prev = cur
+		return usage, true
+	}, nil
+}
+
+//启动CPU采样协程，读取失败时CPU使用率保持为0，即不会因CPU触发限流
+func startCPUSampler() {
+	cpuSamplerOnce.Do(func() {
+		sample, err := newCPUSampler(cgroupRoot)
+		if err != nil {
+			return
+		}
+		go func() {
+			ticker := time.NewTicker(cpuSampleInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				usage, ok := sample()
+				if !ok {
+					continue
+				}
+				smoothed := float64(atomic.LoadInt64(&cpuUsage))*cpuDecay + usage*(1-cpuDecay)
+				atomic.StoreInt64(&cpuUsage, int64(smoothed))
+			}
+		}()
+	})
+}
+
+//CPUUsage 返回滑动平均后的CPU使用率千分比，容器中为相对容器CPU配额的使用率
+func CPUUsage() int64 {
+	return atomic.LoadInt64(&cpuUsage)
+}
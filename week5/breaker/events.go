@@ -0,0 +1,259 @@
+package breaker
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//EventTrip 关闭状态达到熔断条件后打开
+	EventTrip = "trip"
+
+	//EventRecover 半开启探测通过后关闭
+	EventRecover = "recover"
+
+	//EventReopen 半开启探测未通过重新打开
+	EventReopen = "reopen"
+
+	//EventProbeSuccess 半开启探测请求成功
+	EventProbeSuccess = "probe_success"
+
+	//EventProbeFailure 半开启探测请求失败，慢调用也计为失败
+	EventProbeFailure = "probe_failure"
+
+	//EventForceOpen 人工强制打开
+	EventForceOpen = "force_open"
+
+	//EventForceClose 人工强制关闭
+	EventForceClose = "force_close"
+
+	//EventReset 人工重置
+	EventReset = "reset"
+)
+
+const (
+	//窗口统计触发的状态变化
+	reasonWindow = "window"
+
+	//半开启探测触发的状态变化
+	reasonHalfOpen = "half_open"
+
+	//人工操作
+	reasonAdmin = "admin"
+
+	//多实例同步
+	reasonSync = "sync"
+
+	//快照恢复
+	reasonSnapshot = "snapshot"
+)
+
+//DefaultEventBuffer 订阅通道的默认缓冲大小
+var DefaultEventBuffer = 256
+
+//Event 熔断器事件
+type Event struct {
+	//发生时间
+	Time time.Time `json:"time"`
+
+	//策略名
+	Name string `json:"name"`
+
+	//事件类型
+	Type string `json:"type"`
+
+	//变化前的状态
+	From string `json:"from,omitempty"`
+
+	//变化后的状态
+	To string `json:"to,omitempty"`
+
+	//触发原因，window、half_open、admin、sync或snapshot
+	Reason string `json:"reason,omitempty"`
+
+	//探测请求的错误
+	Error string `json:"error,omitempty"`
+
+	//探测请求的耗时
+	Duration time.Duration `json:"duration,omitempty"`
+
+	//事件发生时的窗口统计
+	Window WindowStat `json:"window"`
+}
+
+//事件分发，订阅者处理不及时时丢弃事件，不阻塞请求
+type eventBus struct {
+	//保护subscribers
+	mutex sync.RWMutex
+
+	//订阅者
+	subscribers map[chan Event]struct{}
+
+	//订阅者数量，没有订阅者时不生成事件
+	count int32
+
+	//丢弃的事件数
+	dropped uint64
+}
+
+//全局事件分发
+var events = &eventBus{subscribers: make(map[chan Event]struct{})}
+
+//是否有订阅者
+func (bus *eventBus) active() bool {
+	return atomic.LoadInt32(&bus.count) > 0
+}
+
+//分发事件
+func (bus *eventBus) publish(event Event) {
+	bus.mutex.RLock()
+	defer bus.mutex.RUnlock()
+	for ch := range bus.subscribers {
+		select {
+		case ch <- event:
+		default:
+			atomic.AddUint64(&bus.dropped, 1)
+		}
+	}
+}
+
+//Subscribe 订阅全部熔断器的事件，buffer小于等于0时使用DefaultEventBuffer
+//返回的cancel用于取消订阅并关闭通道，通道已满时新事件会被丢弃
+func Subscribe(buffer int) (<-chan Event, func()) {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	ch := make(chan Event, buffer)
+	events.mutex.Lock()
+	events.subscribers[ch] = struct{}{}
+	atomic.AddInt32(&events.count, 1)
+	events.mutex.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			events.mutex.Lock()
+			delete(events.subscribers, ch)
+			atomic.AddInt32(&events.count, -1)
+			events.mutex.Unlock()
+			close(ch)
+		})
+	}
+}
+
+//DroppedEvents 返回因订阅者处理不及时被丢弃的事件数
+func DroppedEvents() uint64 {
+	return atomic.LoadUint64(&events.dropped)
+}
+
+//生成并分发事件，补充时间、策略名及窗口统计
+func (broker *breaker) emit(event Event) {
+	if !events.active() {
+		return
+	}
+	event.Time = broker.clock.Now()
+	event.Name = broker.name
+	event.Window = broker.counter.GetWindowStat()
+	events.publish(event)
+}
+
+//分发状态变化事件
+func (broker *breaker) emitTransition(eventType string, from int32, to int32, reason string) {
+	broker.emit(Event{Type: eventType, From: StatusName(from), To: StatusName(to), Reason: reason})
+}
+
+//分发半开启探测结束后的状态变化事件
+func (broker *breaker) emitHalfOpenResult() {
+	if broker.counter.GetStatus() == StatusClosed {
+		broker.emitTransition(EventRecover, StatusHalfOpen, StatusClosed, reasonHalfOpen)
+		return
+	}
+	broker.emitTransition(EventReopen, StatusHalfOpen, StatusOpen, reasonHalfOpen)
+}
+
+//分发探测结果事件
+func (broker *breaker) emitProbe(err error, duration time.Duration) {
+	if !events.active() {
+		return
+	}
+	event := Event{Type: EventProbeSuccess, Reason: reasonHalfOpen, Duration: duration}
+	if broker.isFail(err) || broker.counter.IsSlow(duration) {
+		event.Type = EventProbeFailure
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	broker.emit(event)
+}
+
+//JSON-lines文件事件输出，每行一个事件
+type eventFileSink struct {
+	//文件
+	file io.WriteCloser
+
+	//取消订阅
+	cancel func()
+
+	//写入协程结束
+	done chan struct{}
+}
+
+//NewEventFileSink 订阅事件并以JSON-lines格式追加写入path，用于事后审计
+func NewEventFileSink(path string) (*eventFileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ch, cancel := Subscribe(0)
+	sink := &eventFileSink{file: file, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(sink.done)
+		encoder := json.NewEncoder(file)
+		for event := range ch {
+			_ = encoder.Encode(event)
+		}
+	}()
+	return sink, nil
+}
+
+//Close 取消订阅，写完已收到的事件后关闭文件
+func (sink *eventFileSink) Close() error {
+	sink.cancel()
+	<-sink.done
+	return sink.file.Close()
+}
+
+//EventsHandler 以Server-Sent Events推送熔断器事件，query参数name不为空时只推送指定熔断器的事件
+func EventsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Query("name")
+		ch, cancel := Subscribe(0)
+		defer cancel()
+		//先返回响应头，客户端连接后即可确认订阅成功
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event := <-ch:
+				if name == "" || event.Name == name {
+					c.SSEvent("breaker", event)
+				}
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
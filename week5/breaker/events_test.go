@@ -0,0 +1,114 @@
+package breaker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//取出通道中已有的指定熔断器的事件类型
+func drainEvents(ch <-chan Event, name string) []string {
+	var types []string
+	for {
+		select {
+		case event := <-ch:
+			if event.Name == name {
+				types = append(types, event.Type)
+			}
+		default:
+			return types
+		}
+	}
+}
+
+func TestEventsSubscribeAndFileSink(t *testing.T) {
+	ch, cancel := Subscribe(64)
+	defer cancel()
+	path := t.TempDir() + "/events.jsonl"
+	sink, err := NewEventFileSink(path)
+	if err != nil {
+		t.Fatalf("NewEventFileSink() error = %v", err)
+	}
+
+	b, clock := newTestBreaker(t, NewBreakSettingInfo().SetBreakerTestMax(2))
+	doN(t, 10, failRun)
+	clock.Advance(2 * time.Second)
+	doN(t, 2, successRun)
+	assertStatus(t, b, StatusClosed)
+	ForceOpen(t.Name())
+
+	want := []string{EventTrip, EventProbeSuccess, EventProbeSuccess, EventRecover, EventForceOpen}
+	got := drainEvents(ch, t.Name())
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("file has %d lines, want %d", len(lines), len(want))
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if event.Type != EventTrip || event.From != "closed" || event.To != "open" || event.Window.Failed == 0 {
+		t.Fatalf("first event = %+v, want trip with window stats", event)
+	}
+}
+
+func TestEventsHandlerStreamsSSE(t *testing.T) {
+	newTestBreaker(t, NewBreakSettingInfo())
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterAdminRoutes(router.Group("/admin"))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/admin/events?name="+t.Name(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /admin/events error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	//等待处理函数完成订阅
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&events.count) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	ForceOpen(t.Name())
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &event); err != nil {
+			t.Fatalf("Unmarshal(%q) error = %v", line, err)
+		}
+		if event.Type != EventForceOpen || event.Name != t.Name() {
+			t.Fatalf("event = %+v, want force_open", event)
+		}
+		return
+	}
+	t.Fatalf("stream ended without event: %v", scanner.Err())
+}
@@ -0,0 +1,163 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//GrpcClientInterceptor gRPC客户端熔断拦截器，按方法区分熔断器
+type GrpcClientInterceptor struct {
+	//返回方法的策略名，为空时使用完整方法名，如/grpc.health.v1.Health/Check，返回空字符串时不熔断
+	NameFunc func(method string) string
+
+	//判断错误码是否计为失败，为空时使用DefaultGrpcFailureCode
+	IsFailureCode func(code codes.Code) bool
+}
+
+//DefaultGrpcFailureCode 默认的失败判定，只有服务端故障类的错误码计为失败，参数错误、未找到等业务错误不计为失败
+func DefaultGrpcFailureCode(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+//GrpcFailureCodes 返回只将指定错误码计为失败的判定函数
+func GrpcFailureCodes(failureCodes ...codes.Code) func(code codes.Code) bool {
+	failure := make(map[codes.Code]bool, len(failureCodes))
+	for _, code := range failureCodes {
+		failure[code] = true
+	}
+	return func(code codes.Code) bool {
+		return failure[code]
+	}
+}
+
+//经过熔断器执行一次调用，熔断时返回codes.Unavailable，舱壁隔离已满时返回codes.ResourceExhausted
+//错误码不计为失败时错误仍原样返回给调用方，只是不计入熔断器
+func (interceptor *GrpcClientInterceptor) do(ctx context.Context, method string, call func() error) error {
+	name := method
+	if interceptor.NameFunc != nil {
+		name = interceptor.NameFunc(method)
+	}
+	if name == "" {
+		return call()
+	}
+	isFailureCode := interceptor.IsFailureCode
+	if isFailureCode == nil {
+		isFailureCode = DefaultGrpcFailureCode
+	}
+
+	var callErr, rejectErr error
+	runErr := Do(ctx, name, func() error {
+		callErr = call()
+		if callErr != nil && isFailureCode(status.Code(callErr)) {
+			return callErr
+		}
+		return nil
+	}, func(err error) {
+		if errors.Is(err, OpenError) || errors.Is(err, ErrBulkheadFull) {
+			rejectErr = err
+		}
+	})
+	switch {
+	case errors.Is(rejectErr, OpenError):
+		return status.Error(codes.Unavailable, fmt.Sprintf("breaker %q open", name))
+	case errors.Is(rejectErr, ErrBulkheadFull):
+		return status.Error(codes.ResourceExhausted, fmt.Sprintf("breaker %q bulkhead full", name))
+	}
+	if callErr != nil {
+		return callErr
+	}
+	//获取熔断器失败或调用panic
+	return runErr
+}
+
+//Unary 返回一元调用的客户端拦截器，通过grpc.WithUnaryInterceptor使用
+func (interceptor *GrpcClientInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return interceptor.do(ctx, method, func() error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
+
+//Stream 返回流式调用的客户端拦截器，通过grpc.WithStreamInterceptor使用
+//只按建立流的结果计数，建立之后收发消息的错误不计入熔断器
+func (interceptor *GrpcClientInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		var clientStream grpc.ClientStream
+		err := interceptor.do(ctx, method, func() error {
+			var err error
+			clientStream, err = streamer(ctx, desc, cc, method, opts...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return clientStream, nil
+	}
+}
+
+//GrpcServerInterceptor gRPC服务端限流及过载保护拦截器
+//限流返回codes.ResourceExhausted，过载丢弃返回codes.Unavailable，与http中间件的429、503对应
+type GrpcServerInterceptor struct {
+	//限流器集合，为空时不限流
+	Limiters *limiterGroup
+
+	//返回限流key，为空时按完整方法名限流
+	KeyFunc func(ctx context.Context, method string) string
+
+	//自适应过载保护，为空时不做过载保护
+	Limiter *bbrLimiter
+}
+
+//判断是否放行，放行时返回的done需要在请求结束后调用
+func (interceptor *GrpcServerInterceptor) allow(ctx context.Context, method string) (func(), error) {
+	if interceptor.Limiters != nil {
+		key := method
+		if interceptor.KeyFunc != nil {
+			key = interceptor.KeyFunc(ctx, method)
+		}
+		if !interceptor.Limiters.Allow(key) {
+			return nil, status.Error(codes.ResourceExhausted, RateLimitError.Error())
+		}
+	}
+	if interceptor.Limiter != nil {
+		done, err := interceptor.Limiter.Allow()
+		if err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return done, nil
+	}
+	return func() {}, nil
+}
+
+//Unary 返回一元调用的服务端拦截器，通过grpc.UnaryInterceptor使用
+func (interceptor *GrpcServerInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done, err := interceptor.allow(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		return handler(ctx, req)
+	}
+}
+
+//Stream 返回流式调用的服务端拦截器，通过grpc.StreamInterceptor使用，流结束前一直计为在途请求
+func (interceptor *GrpcServerInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done, err := interceptor.allow(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer done()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,152 @@
+package breaker
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+//按设置的错误码返回的健康检查服务
+type fakeHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	code  int32
+	calls int32
+}
+
+func (server *fakeHealthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	atomic.AddInt32(&server.calls, 1)
+	if code := codes.Code(atomic.LoadInt32(&server.code)); code != codes.OK {
+		return nil, status.Error(code, code.String())
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (server *fakeHealthServer) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	atomic.AddInt32(&server.calls, 1)
+	return stream.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+}
+
+//通过内存连接启动服务并返回客户端
+func startGrpcServer(t *testing.T, health *fakeHealthServer, serverOpts []grpc.ServerOption, dialOpts ...grpc.DialOption) grpc_health_v1.HealthClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(serverOpts...)
+	grpc_health_v1.RegisterHealthServer(server, health)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	dialOpts = append(dialOpts, grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	conn, err := grpc.DialContext(context.Background(), "bufnet", dialOpts...)
+	if err != nil {
+		t.Fatalf("grpc.DialContext() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+//调用一次健康检查并返回错误码
+func checkCode(client grpc_health_v1.HealthClient) codes.Code {
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	return status.Code(err)
+}
+
+func TestGrpcClientInterceptorTrips(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo())
+	interceptor := &GrpcClientInterceptor{NameFunc: func(method string) string {
+		return t.Name()
+	}}
+	health := &fakeHealthServer{}
+	client := startGrpcServer(t, health, nil,
+		grpc.WithUnaryInterceptor(interceptor.Unary()), grpc.WithStreamInterceptor(interceptor.Stream()))
+
+	//业务错误码原样返回且不计为失败
+	atomic.StoreInt32(&health.code, int32(codes.NotFound))
+	for i := 0; i < 10; i++ {
+		if code := checkCode(client); code != codes.NotFound {
+			t.Fatalf("code = %v, want NotFound", code)
+		}
+	}
+	assertStatus(t, b, StatusClosed)
+
+	//服务端故障达到熔断条件后不再请求服务端
+	atomic.StoreInt32(&health.code, int32(codes.Internal))
+	for i := 0; i < 10; i++ {
+		if code := checkCode(client); code != codes.Internal {
+			t.Fatalf("code = %v, want Internal", code)
+		}
+	}
+	assertStatus(t, b, StatusOpen)
+	calls := atomic.LoadInt32(&health.calls)
+	if code := checkCode(client); code != codes.Unavailable {
+		t.Fatalf("code = %v, want Unavailable", code)
+	}
+	if got := atomic.LoadInt32(&health.calls); got != calls {
+		t.Fatalf("server calls = %d, want %d", got, calls)
+	}
+}
+
+func TestGrpcClientInterceptorStream(t *testing.T) {
+	newTestBreaker(t, NewBreakSettingInfo())
+	interceptor := &GrpcClientInterceptor{NameFunc: func(method string) string {
+		return t.Name()
+	}}
+	client := startGrpcServer(t, &fakeHealthServer{}, nil, grpc.WithStreamInterceptor(interceptor.Stream()))
+
+	stream, err := client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+
+	ForceOpen(t.Name())
+	_, err = client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Fatalf("Watch() code = %v, want Unavailable", code)
+	}
+}
+
+func TestGrpcServerInterceptorRateLimit(t *testing.T) {
+	interceptor := &GrpcServerInterceptor{Limiters: NewLimiterGroup(func(key string) Limiter {
+		bucket, _ := NewTokenBucket(0, 2)
+		return bucket
+	})}
+	health := &fakeHealthServer{}
+	client := startGrpcServer(t, health, []grpc.ServerOption{
+		grpc.UnaryInterceptor(interceptor.Unary()), grpc.StreamInterceptor(interceptor.Stream()),
+	})
+
+	for i := 0; i < 2; i++ {
+		if code := checkCode(client); code != codes.OK {
+			t.Fatalf("code = %v, want OK", code)
+		}
+	}
+	if code := checkCode(client); code != codes.ResourceExhausted {
+		t.Fatalf("code = %v, want ResourceExhausted", code)
+	}
+	if calls := atomic.LoadInt32(&health.calls); calls != 2 {
+		t.Fatalf("server calls = %d, want 2", calls)
+	}
+
+	//流式调用使用独立的限流key
+	stream, err := client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+}
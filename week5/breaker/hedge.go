@@ -0,0 +1,123 @@
+package breaker
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+//HedgePolicy 对冲请求策略，对冲等待时间不大于0时不发起对冲请求
+type HedgePolicy struct {
+	//第一次请求超过该时间未返回时发起对冲请求，为0时只在Percentile有统计数据后对冲
+	Delay time.Duration
+
+	//动态返回对冲等待时间，不为空时优先于Percentile及Delay
+	DelayFunc func() time.Duration
+
+	//按窗口内耗时的分位数作为对冲等待时间，如0.95，大于0时优先于Delay，窗口内没有请求时使用Delay
+	Percentile float64
+
+	//对冲预算，限制对冲请求占请求数的比例，为空时不限制
+	Budget *retryBudget
+}
+
+//对冲等待时间，不大于0表示不对冲，避免冷启动窗口内没有耗时统计时每个请求都立即对冲
+func (policy *HedgePolicy) delay(broker *breaker) time.Duration {
+	if policy.DelayFunc != nil {
+		return policy.DelayFunc()
+	}
+	if policy.Percentile > 0 {
+		if delay := broker.counter.Percentile(policy.Percentile); delay > 0 {
+			return delay
+		}
+	}
+	return policy.Delay
+}
+
+//单次执行结果
+type hedgeResult struct {
+	duration time.Duration
+	err      error
+}
+
+//按对冲策略执行run，第一次请求超时未返回时发起第二次请求，返回先成功的结果并取消另一个请求
+//熔断器不处于关闭状态或预算不足时不发起对冲请求
+func (broker *breaker) hedgeExec(ctx context.Context, policy *HedgePolicy, run func(ctx context.Context) error) execFunc {
+	return func() (time.Duration, error) {
+		if policy.Budget != nil {
+			policy.Budget.deposit()
+		}
+		hedgeCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		start := broker.clock.Now()
+		results := make(chan hedgeResult, 2)
+		launch := func() {
+			go func() {
+				_, err := broker.safeRun(func() error {
+					return run(hedgeCtx)
+				})
+				results <- hedgeResult{duration: broker.clock.Now().Sub(start), err: err}
+			}()
+		}
+		launch()
+
+		//不对冲时timeout为nil，只等待第一次请求的结果
+		var timeout <-chan time.Time
+		if delay := policy.delay(broker); delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		pending := 1
+		var lastErr error
+		for {
+			select {
+			case res := <-results:
+				pending--
+				if res.err == nil {
+					return res.duration, nil
+				}
+				lastErr = res.err
+				//第一次请求快速失败时不再对冲
+				if pending == 0 {
+					return res.duration, lastErr
+				}
+			case <-timeout:
+				if broker.State() != StatusClosed {
+					continue
+				}
+				if policy.Budget != nil && !policy.Budget.withdraw() {
+					continue
+				}
+				atomic.AddUint64(&broker.metrics.hedges, 1)
+				pending++
+				launch()
+			}
+		}
+	}
+}
+
+//DoHedged 按对冲策略结合熔断策略执行run函数，run需要响应ctx取消，其余参数与Do相同
+//一次调用无论是否对冲，滑动窗口中只按最终结果计数一次，耗时为从第一次请求开始到得到结果的时间
+func DoHedged(ctx context.Context, name string, policy *HedgePolicy, run func(ctx context.Context) error, fallback fallbackFunc) error {
+	if run == nil {
+		return FuncNilError
+	}
+	if policy == nil {
+		return Do(ctx, name, func() error {
+			return run(ctx)
+		}, fallback)
+	}
+	if name == "" {
+		return NameNilError
+	}
+	breaker, err := getBreakerManager(name)
+	if err != nil {
+		if fallback != nil {
+			fallback(err)
+		}
+		return err
+	}
+	return breaker.do(ctx, breaker.hedgeExec(ctx, policy, run), fallback)
+}
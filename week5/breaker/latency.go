@@ -0,0 +1,131 @@
+package breaker
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//耗时直方图的格子数，最后一个格子记录超过上限的耗时
+	latencyBuckets = 80
+
+	//第一个格子的上限
+	latencyBase = 100 * time.Microsecond
+)
+
+//耗时直方图各格子的上限，每个格子是上一个的2的四分之一次方倍，范围100微秒到约100秒，误差不超过约19%
+var latencyBounds = func() [latencyBuckets]time.Duration {
+	var bounds [latencyBuckets]time.Duration
+	for idx := range bounds {
+		bounds[idx] = time.Duration(float64(latencyBase) * math.Pow(2, float64(idx)/4))
+	}
+	return bounds
+}()
+
+//耗时所在的格子
+func latencyBucket(duration time.Duration) int {
+	if duration <= latencyBase {
+		return 0
+	}
+	idx := int(math.Ceil(4 * math.Log2(float64(duration)/float64(latencyBase))))
+	//浮点误差修正
+	for idx > 0 && duration <= latencyBounds[idx-1] {
+		idx--
+	}
+	for idx < latencyBuckets-1 && duration > latencyBounds[idx] {
+		idx++
+	}
+	if idx >= latencyBuckets {
+		return latencyBuckets - 1
+	}
+	return idx
+}
+
+//LatencyStat 窗口内的耗时分位数
+type LatencyStat struct {
+	Count uint32        `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+}
+
+//合并窗口内的耗时直方图
+func (slidingWindow *SlidingWindow) histogram() (buckets [latencyBuckets]uint32, count uint32) {
+	slidingWindow.ring.forEach(slidingWindow.clock.Now().UnixNano(), func(data *gridData) {
+		if data.buckets == nil {
+			return
+		}
+		for idx := range data.buckets {
+			n := atomic.LoadUint32(&data.buckets[idx])
+			buckets[idx] += n
+			count += n
+		}
+	})
+	return buckets, count
+}
+
+//按直方图计算分位数，在格子内线性插值
+func percentile(buckets *[latencyBuckets]uint32, count uint32, p float64) time.Duration {
+	if count == 0 {
+		return 0
+	}
+	if p < 0 {
+		p = 0
+	}
+	if p > 1 {
+		p = 1
+	}
+	rank := p * float64(count)
+	var cumulative float64
+	for idx, n := range buckets {
+		if n == 0 {
+			continue
+		}
+		if cumulative+float64(n) >= rank {
+			var lower time.Duration
+			if idx > 0 {
+				lower = latencyBounds[idx-1]
+			}
+			upper := latencyBounds[idx]
+			return lower + time.Duration(float64(upper-lower)*(rank-cumulative)/float64(n))
+		}
+		cumulative += float64(n)
+	}
+	return latencyBounds[latencyBuckets-1]
+}
+
+//Percentile 返回窗口内耗时的p分位数，p在0到1之间，窗口内没有请求时返回0
+func (slidingWindow *SlidingWindow) Percentile(p float64) time.Duration {
+	buckets, count := slidingWindow.histogram()
+	return percentile(&buckets, count, p)
+}
+
+//GetLatencyStat 返回窗口内耗时的p50、p95及p99
+func (slidingWindow *SlidingWindow) GetLatencyStat() LatencyStat {
+	buckets, count := slidingWindow.histogram()
+	return LatencyStat{
+		Count: count,
+		P50:   percentile(&buckets, count, 0.5),
+		P95:   percentile(&buckets, count, 0.95),
+		P99:   percentile(&buckets, count, 0.99),
+	}
+}
+
+//Latency 返回指定熔断器窗口内的耗时分位数，熔断器不存在时返回false
+func Latency(name string) (LatencyStat, bool) {
+	broker, ok := bm.get(name)
+	if !ok {
+		return LatencyStat{}, false
+	}
+	return broker.counter.GetLatencyStat(), true
+}
+
+//LatencyPercentile 返回指定熔断器窗口内耗时的p分位数，熔断器不存在或窗口内没有请求时返回0
+func LatencyPercentile(name string, p float64) time.Duration {
+	broker, ok := bm.get(name)
+	if !ok {
+		return 0
+	}
+	return broker.counter.Percentile(p)
+}
@@ -32,6 +32,15 @@ func (limitPoolManager *limitPoolManager) ReturnAll() {
 	}
 }
 
+//Refill 方法将令牌补足到最大数量，不要求令牌已用完，用于半开启中途被其他实例的状态打断时
+func (limitPoolManager *limitPoolManager) Refill() {
+	limitPoolManager.lock.Lock()
+	defer limitPoolManager.lock.Unlock()
+	for len(limitPoolManager.tickets) < limitPoolManager.max {
+		limitPoolManager.tickets <- &struct{}{}
+	}
+}
+
 //GetTicket 方法返回一个令牌，得到令牌返回true，令牌用完后返回false
 func (limitPoolManager *limitPoolManager) GetTicket() bool {
 	limitPoolManager.lock.RLock()
@@ -0,0 +1,128 @@
+package breaker
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//记录熔断器最近一次使用时间
+func (broker *breaker) touch() {
+	atomic.StoreInt64(&broker.lastUsed, broker.clock.Now().UnixNano())
+}
+
+//判断熔断器空闲时间是否超过idleTTL
+func (broker *breaker) idle(idleTTL time.Duration) bool {
+	return broker.clock.Now().UnixNano()-atomic.LoadInt64(&broker.lastUsed) > int64(idleTTL)
+}
+
+//Close 关闭熔断器并从管理器中删除，重复关闭不会报错
+//关闭后熔断器不再计数及发出事件，仍持有该熔断器的调用改用管理器中同名的熔断器，不存在时按配置重新创建
+func (broker *breaker) Close() error {
+	atomic.StoreInt32(&broker.closed, 1)
+	bm.mutex.Lock()
+	if bm.manager[broker.name] == broker {
+		delete(bm.manager, broker.name)
+	}
+	bm.mutex.Unlock()
+	return nil
+}
+
+//IsClosed 判断熔断器是否已关闭
+func (broker *breaker) IsClosed() bool {
+	return atomic.LoadInt32(&broker.closed) == 1
+}
+
+//从管理器中删除熔断器并关闭
+func (breakerManager *breakerManager) remove(name string) bool {
+	breakerManager.mutex.Lock()
+	broker, ok := breakerManager.manager[name]
+	if ok {
+		delete(breakerManager.manager, name)
+	}
+	breakerManager.mutex.Unlock()
+	if ok {
+		_ = broker.Close()
+	}
+	return ok
+}
+
+//使用原配置重建熔断器，清空窗口统计并恢复为关闭状态，累计指标保留
+func (breakerManager *breakerManager) reset(name string) bool {
+	breakerManager.mutex.Lock()
+	old, ok := breakerManager.manager[name]
+	if ok {
+		broker := newBreaker(old.setting)
+		broker.configured = old.configured
+		//累计指标只增不减，重置只清空窗口统计
+		broker.metrics.inherit(&old.metrics)
+		breakerManager.manager[name] = broker
+	}
+	breakerManager.mutex.Unlock()
+	if ok {
+		_ = old.Close()
+		old.emitTransition(EventReset, old.State(), StatusClosed, reasonAdmin)
+	}
+	return ok
+}
+
+//淘汰空闲时间超过idleTTL的熔断器，返回淘汰个数
+//只淘汰由配置或默认值创建的熔断器，代码注册的熔断器淘汰后会按配置重建而丢失注册时的设置，因此保留
+func (breakerManager *breakerManager) evictIdle(idleTTL time.Duration) int {
+	var evicted []*breaker
+	breakerManager.mutex.Lock()
+	for name, broker := range breakerManager.manager {
+		if broker.configured && broker.idle(idleTTL) {
+			delete(breakerManager.manager, name)
+			evicted = append(evicted, broker)
+		}
+	}
+	breakerManager.mutex.Unlock()
+	for _, broker := range evicted {
+		_ = broker.Close()
+	}
+	return len(evicted)
+}
+
+//Remove 从全局管理器中删除并关闭指定熔断器，不存在时返回false
+func Remove(name string) bool {
+	return bm.remove(name)
+}
+
+//Reset 将指定熔断器重置为初始状态，配置及累计指标保持不变，不存在时返回false
+func Reset(name string) bool {
+	return bm.reset(name)
+}
+
+//StartIdleEviction 每隔interval淘汰空闲时间超过idleTTL的熔断器，代码注册的熔断器不会被淘汰，重复调用会替换之前的淘汰任务
+func StartIdleEviction(idleTTL time.Duration, interval time.Duration) {
+	stop := make(chan struct{})
+	bm.mutex.Lock()
+	if bm.evictStop != nil {
+		close(bm.evictStop)
+	}
+	bm.evictStop = stop
+	bm.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				bm.evictIdle(idleTTL)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+//StopIdleEviction 停止空闲淘汰任务
+func StopIdleEviction() {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	if bm.evictStop != nil {
+		close(bm.evictStop)
+		bm.evictStop = nil
+	}
+}
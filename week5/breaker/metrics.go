@@ -0,0 +1,143 @@
+package breaker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+//熔断器累计指标，只增不减
+type breakerMetrics struct {
+	//请求数
+	requests uint64
+
+	//失败数
+	failures uint64
+
+	//熔断拒绝数
+	rejected uint64
+
+	//半开启探测数
+	probes uint64
+
+	//舱壁隔离拒绝数
+	bulkheadRejected uint64
+
+	//重试数
+	retries uint64
+
+	//对冲请求数
+	hedges uint64
+}
+
+//按名称排序返回管理器中的全部熔断器
+func (breakerManager *breakerManager) list() []*breaker {
+	breakerManager.mutex.RLock()
+	breakers := make([]*breaker, 0, len(breakerManager.manager))
+	for _, broker := range breakerManager.manager {
+		breakers = append(breakers, broker)
+	}
+	breakerManager.mutex.RUnlock()
+	sort.Slice(breakers, func(i, j int) bool {
+		return breakers[i].name < breakers[j].name
+	})
+	return breakers
+}
+
+//指标描述
+type metricDesc struct {
+	name  string
+	help  string
+	kind  string
+	value func(broker *breaker) float64
+}
+
+//全部指标
+var metricDescs = []metricDesc{
+	{"breaker_requests_total", "Total calls passed to the breaker.", "counter", func(broker *breaker) float64 {
+		return float64(atomic.LoadUint64(&broker.metrics.requests))
+	}},
+	{"breaker_failures_total", "Total calls counted as failures.", "counter", func(broker *breaker) float64 {
+		return float64(atomic.LoadUint64(&broker.metrics.failures))
+	}},
+	{"breaker_rejected_total", "Total calls rejected with OpenError.", "counter", func(broker *breaker) float64 {
+		return float64(atomic.LoadUint64(&broker.metrics.rejected))
+	}},
+	{"breaker_half_open_probes_total", "Total probe calls executed while half-open.", "counter", func(broker *breaker) float64 {
+		return float64(atomic.LoadUint64(&broker.metrics.probes))
+	}},
+	{"breaker_bulkhead_rejected_total", "Total calls rejected with ErrBulkheadFull.", "counter", func(broker *breaker) float64 {
+		return float64(atomic.LoadUint64(&broker.metrics.bulkheadRejected))
+	}},
+	{"breaker_retries_total", "Total retry attempts made by DoWithRetry.", "counter", func(broker *breaker) float64 {
+		return float64(atomic.LoadUint64(&broker.metrics.retries))
+	}},
+	{"breaker_hedges_total", "Total hedged attempts launched by DoHedged.", "counter", func(broker *breaker) float64 {
+		return float64(atomic.LoadUint64(&broker.metrics.hedges))
+	}},
+	{"breaker_state", "Current state: 0 closed, 1 open, 2 half-open.", "gauge", func(broker *breaker) float64 {
+		return float64(broker.State())
+	}},
+	{"breaker_window_requests", "Calls recorded in the current sliding window.", "gauge", func(broker *breaker) float64 {
+		return float64(broker.counter.GetWindowStat().Total)
+	}},
+	{"breaker_window_failures", "Failures recorded in the current sliding window.", "gauge", func(broker *breaker) float64 {
+		return float64(broker.counter.GetWindowStat().Failed)
+	}},
+	{"breaker_window_slow_calls", "Slow calls recorded in the current sliding window.", "gauge", func(broker *breaker) float64 {
+		return float64(broker.counter.GetWindowStat().Slow)
+	}},
+	{"breaker_window_latency_p50_seconds", "Median call latency in the current sliding window.", "gauge", func(broker *breaker) float64 {
+		return broker.counter.Percentile(0.5).Seconds()
+	}},
+	{"breaker_window_latency_p95_seconds", "95th percentile call latency in the current sliding window.", "gauge", func(broker *breaker) float64 {
+		return broker.counter.Percentile(0.95).Seconds()
+	}},
+	{"breaker_window_latency_p99_seconds", "99th percentile call latency in the current sliding window.", "gauge", func(broker *breaker) float64 {
+		return broker.counter.Percentile(0.99).Seconds()
+	}},
+	{"breaker_half_open_tickets", "Remaining half-open probe tickets.", "gauge", func(broker *breaker) float64 {
+		return float64(broker.lpm.GetRemainder())
+	}},
+	{"breaker_in_flight", "Calls currently executing, 0 when no bulkhead is configured.", "gauge", func(broker *breaker) float64 {
+		if broker.bulkhead == nil {
+			return 0
+		}
+		return float64(broker.bulkhead.InFlight())
+	}},
+	{"breaker_adaptive_reject_probability", "Current reject probability in adaptive mode, 0 otherwise.", "gauge", func(broker *breaker) float64 {
+		if broker.adaptiveK <= 0 {
+			return 0
+		}
+		return broker.rejectProbability()
+	}},
+}
+
+//转义标签值
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//WriteMetrics 以Prometheus文本格式输出全部熔断器的指标
+//累计指标在Reset及配置重载后保持不变，Remove或空闲淘汰后该熔断器的指标不再输出，同名熔断器重新创建时从0开始计数，Prometheus按计数器重置处理
+func WriteMetrics(w io.Writer) error {
+	breakers := bm.list()
+	buf := bufio.NewWriter(w)
+	for _, desc := range metricDescs {
+		_, _ = fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", desc.name, desc.help, desc.name, desc.kind)
+		for _, broker := range breakers {
+			_, _ = fmt.Fprintf(buf, "%s{name=\"%s\"} %g\n", desc.name, labelEscaper.Replace(broker.name), desc.value(broker))
+		}
+	}
+	return buf.Flush()
+}
+
+//MetricsHandler 返回输出熔断器指标的http.Handler，可挂载到/metrics
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteMetrics(w)
+	})
+}
@@ -0,0 +1,70 @@
+package breaker
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "更新testdata中的golden文件")
+
+//只保留指定熔断器的指标行及注释行
+func metricsFor(t *testing.T, name string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf); err != nil {
+		t.Fatalf("WriteMetrics() error = %v", err)
+	}
+	label := `{name="` + labelEscaper.Replace(name) + `"}`
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if strings.HasPrefix(line, "#") || strings.Contains(line, label) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestWriteMetricsGolden(t *testing.T) {
+	//标签值中的引号、反斜杠及换行需要转义
+	name := "metrics/\"quoted\"\\path\nline"
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	if _, err := Register(name, WithClock(clock), WithWindow(10*time.Second, 10)); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	t.Cleanup(func() {
+		Remove(name)
+	})
+	call := func(latency time.Duration, err error) {
+		_ = Do(context.Background(), name, func() error {
+			clock.Advance(latency)
+			return err
+		}, nil)
+	}
+	for i := 0; i < 4; i++ {
+		call(10*time.Millisecond, nil)
+	}
+	call(40*time.Millisecond, errDependency)
+	//重置只清空窗口，累计指标继续增加
+	Reset(name)
+	call(10*time.Millisecond, nil)
+
+	got := metricsFor(t, name)
+	path := "testdata/metrics.golden"
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if got != string(want) {
+		t.Fatalf("metrics mismatch, run with -update to regenerate\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
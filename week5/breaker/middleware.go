@@ -0,0 +1,68 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+//HttpStatusError 响应状态码被判定为失败
+var HttpStatusError = errors.New("http status failure")
+
+//GinFallbackFunc 熔断时的降级响应，只需写入响应，中间件会负责中止后续handler
+type GinFallbackFunc func(c *gin.Context, err error)
+
+//DefaultGinFallback 默认降级响应，熔断时返回503，舱壁隔离已满时返回429
+func DefaultGinFallback(c *gin.Context, err error) {
+	status := http.StatusServiceUnavailable
+	if errors.Is(err, ErrBulkheadFull) {
+		status = http.StatusTooManyRequests
+	}
+	c.AbortWithStatusJSON(status, gin.H{
+		"message": err.Error(),
+	})
+}
+
+//GinMiddleware 使用熔断器保护路由，nameFunc返回策略名，为空时使用路由路径
+//响应状态码为5xx时计为失败，熔断或舱壁隔离已满时调用fallback返回降级响应，未传fallback时使用DefaultGinFallback
+//fallback收到OpenError或ErrBulkheadFull，返回后中间件会调用c.Abort()，后续handler不会执行
+func GinMiddleware(nameFunc func(c *gin.Context) string, fallback ...GinFallbackFunc) gin.HandlerFunc {
+	fallbackFn := DefaultGinFallback
+	if len(fallback) > 0 && fallback[0] != nil {
+		fallbackFn = fallback[0]
+	}
+	return func(c *gin.Context) {
+		name := c.FullPath()
+		if nameFunc != nil {
+			name = nameFunc(c)
+		}
+		//未匹配路由时不做熔断
+		if name == "" {
+			c.Next()
+			return
+		}
+		var rejectErr error
+		runErr := Do(c.Request.Context(), name, func() error {
+			c.Next()
+			if status := c.Writer.Status(); status >= http.StatusInternalServerError {
+				return fmt.Errorf("%w: %d", HttpStatusError, status)
+			}
+			return nil
+		}, func(err error) {
+			//熔断器自身的拒绝，handler未执行
+			if errors.Is(err, OpenError) || errors.Is(err, ErrBulkheadFull) {
+				rejectErr = err
+			}
+		})
+		//handler的panic交还给gin.Recovery处理
+		if errors.Is(runErr, PanicError) {
+			panic(runErr)
+		}
+		if rejectErr != nil {
+			fallbackFn(c, rejectErr)
+			//fallback未调用Abort时也不再执行后续handler
+			c.Abort()
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package breaker
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+//注册被熔断器保护的路由，handler返回status并记录调用次数
+func newGuardedRouter(t *testing.T, status *int, calls *int, fallback ...GinFallbackFunc) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/guarded", GinMiddleware(func(c *gin.Context) string {
+		return t.Name()
+	}, fallback...), func(c *gin.Context) {
+		*calls++
+		c.String(*status, "handler")
+	})
+	return router
+}
+
+//请求受保护的路由并返回状态码
+func getGuarded(router *gin.Engine) int {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/guarded", nil))
+	return w.Code
+}
+
+func TestGinMiddlewareCountsServerErrors(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo())
+	status, calls := http.StatusNotFound, 0
+	router := newGuardedRouter(t, &status, &calls)
+
+	//4xx不计为失败
+	for i := 0; i < DefaultMinRequests; i++ {
+		if code := getGuarded(router); code != http.StatusNotFound {
+			t.Fatalf("code = %d, want 404", code)
+		}
+	}
+	assertStatus(t, b, StatusClosed)
+
+	//5xx计为失败，熔断后返回503且不执行handler
+	status = http.StatusInternalServerError
+	for i := 0; i < DefaultMinRequests; i++ {
+		getGuarded(router)
+	}
+	assertStatus(t, b, StatusOpen)
+	calls = 0
+	if code := getGuarded(router); code != http.StatusServiceUnavailable {
+		t.Fatalf("code = %d, want 503", code)
+	}
+	if calls != 0 {
+		t.Fatalf("handler calls = %d while open, want 0", calls)
+	}
+}
+
+func TestGinMiddlewareAbortsAfterCustomFallback(t *testing.T) {
+	newTestBreaker(t, NewBreakSettingInfo())
+	status, calls := http.StatusOK, 0
+	//fallback只写响应，不调用Abort
+	router := newGuardedRouter(t, &status, &calls, func(c *gin.Context, err error) {
+		c.String(http.StatusTooManyRequests, "fallback")
+	})
+	ForceOpen(t.Name())
+
+	if code := getGuarded(router); code != http.StatusTooManyRequests {
+		t.Fatalf("code = %d, want 429", code)
+	}
+	if calls != 0 {
+		t.Fatalf("handler calls = %d while open, want 0", calls)
+	}
+}
+
+func TestGinMiddlewareBulkheadFull(t *testing.T) {
+	newTestBreaker(t, NewBreakSettingInfo().SetBulkhead(1, 0, 0))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	calls := int32(0)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/guarded", GinMiddleware(func(c *gin.Context) string {
+		return t.Name()
+	}), func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-release
+		}
+		c.String(http.StatusOK, "handler")
+	})
+
+	first := make(chan int, 1)
+	go func() {
+		first <- getGuarded(router)
+	}()
+	<-started
+	//舱壁已满的请求中止并返回429，不执行handler
+	if code := getGuarded(router); code != http.StatusTooManyRequests {
+		t.Fatalf("code = %d, want 429", code)
+	}
+	close(release)
+	if code := <-first; code != http.StatusOK {
+		t.Fatalf("first code = %d, want 200", code)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("handler calls = %d, want 1", got)
+	}
+}
@@ -0,0 +1,109 @@
+package breaker
+
+import "time"
+
+//Option 熔断器配置项，用于NewBreakSettingInfo及Register
+type Option func(*breakSettingInfo)
+
+//WithWindow 设置采样周期及格子数，周期需为格子数的整数倍
+func WithWindow(interval time.Duration, gridNum int) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetWindow(interval, gridNum)
+	}
+}
+
+//WithSleepWindow 设置熔断休眠时间
+func WithSleepWindow(sleepWindow time.Duration) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetSleepWindowDuration(sleepWindow)
+	}
+}
+
+//WithBreakerTestMax 设置半开启状态的探测请求数
+func WithBreakerTestMax(breakerTestMax int) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetBreakerTestMax(breakerTestMax)
+	}
+}
+
+//WithErrorPercentThreshold 设置关闭状态的熔断错误比
+func WithErrorPercentThreshold(errorPercentThreshold int) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetErrorPercentThreshold(errorPercentThreshold)
+	}
+}
+
+//WithBreakerErrorPercentThreshold 设置半开启状态的错误比
+func WithBreakerErrorPercentThreshold(breakerErrorPercentThreshold int) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetBreakerErrorPercentThreshold(breakerErrorPercentThreshold)
+	}
+}
+
+//WithMinRequests 设置按比例熔断需要的最小请求数
+func WithMinRequests(minRequests int) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetMinRequests(minRequests)
+	}
+}
+
+//WithSlowCall 设置慢调用熔断
+func WithSlowCall(slowCallDuration time.Duration, slowCallPercent int) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetSlowCall(slowCallDuration, slowCallPercent)
+	}
+}
+
+//WithTripStrategy 设置熔断策略
+func WithTripStrategy(tripStrategy TripStrategy) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetTripStrategy(tripStrategy)
+	}
+}
+
+//WithClock 设置时钟
+func WithClock(clock Clock) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetClock(clock)
+	}
+}
+
+//WithAdaptive 使用自适应限流模式代替熔断
+func WithAdaptive(k float64) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetAdaptive(k)
+	}
+}
+
+//WithRandom 设置自适应限流使用的随机数函数
+func WithRandom(random func() float64) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetRandom(random)
+	}
+}
+
+//WithBulkhead 设置舱壁隔离
+func WithBulkhead(maxConcurrent int, maxQueue int, queueTimeout time.Duration) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetBulkhead(maxConcurrent, maxQueue, queueTimeout)
+	}
+}
+
+//WithIsFailure 设置失败判定函数
+func WithIsFailure(isFailure func(error) bool) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetIsFailure(isFailure)
+	}
+}
+
+//WithIgnoreErrors 设置不计入熔断失败的错误
+func WithIgnoreErrors(errs ...error) Option {
+	return func(brokerSettingInfo *breakSettingInfo) {
+		brokerSettingInfo.SetIgnoreErrors(errs...)
+	}
+}
+
+//Register 按配置项创建熔断器并注册到管理器，规则同AddBreakSetting
+func Register(name string, opts ...Option) (*breaker, error) {
+	return NewBreakSettingInfo(opts...).SetName(name).AddBreakSetting()
+}
@@ -0,0 +1,355 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//RateLimitError 请求被限流
+var RateLimitError = errors.New("rate limited")
+
+//Limiter 限流器
+type Limiter interface {
+	//Allow 立即判断是否放行，不等待
+	Allow() bool
+
+	//Wait 等待直到放行，ctx结束或无法放行时返回错误
+	Wait(ctx context.Context) error
+}
+
+//令牌桶
+type tokenBucket struct {
+	mutex sync.Mutex
+
+	//每秒生成的令牌数
+	rate float64
+
+	//桶容量，即允许的突发请求数
+	burst float64
+
+	//当前令牌数，Wait预占令牌时可能为负数
+	tokens float64
+
+	//上次补充令牌的时间
+	last time.Time
+
+	//时钟
+	clock Clock
+}
+
+//NewTokenBucket 创建令牌桶，每秒生成rate个令牌，最多累积burst个
+//rate为0时只有初始的burst个令牌，rate为负数、NaN、无穷大或burst小于1时返回InvalidSettingError
+func NewTokenBucket(rate float64, burst int) (*tokenBucket, error) {
+	if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return nil, fmt.Errorf("%w: token bucket rate %g must be a non-negative finite number", InvalidSettingError, rate)
+	}
+	if burst < 1 {
+		return nil, fmt.Errorf("%w: token bucket burst %d must be at least 1", InvalidSettingError, burst)
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   DefaultClock.Now(),
+		clock:  DefaultClock,
+	}, nil
+}
+
+//SetClock 设置时钟
+func (bucket *tokenBucket) SetClock(clock Clock) *tokenBucket {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.clock = clock
+	bucket.last = clock.Now()
+	return bucket
+}
+
+//按经过的时间补充令牌，调用方需持有锁
+func (bucket *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(bucket.last)
+	if elapsed <= 0 {
+		return
+	}
+	bucket.last = now
+	bucket.tokens += elapsed.Seconds() * bucket.rate
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+}
+
+//Allow 有令牌时取走一个并返回true
+func (bucket *tokenBucket) Allow() bool {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.refill(bucket.clock.Now())
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+//Wait 预占一个令牌并等待令牌生成，ctx提前结束时归还令牌
+func (bucket *tokenBucket) Wait(ctx context.Context) error {
+	bucket.mutex.Lock()
+	bucket.refill(bucket.clock.Now())
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.mutex.Unlock()
+		return nil
+	}
+	if bucket.rate <= 0 {
+		bucket.mutex.Unlock()
+		return RateLimitError
+	}
+	bucket.tokens--
+	wait := time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+	bucket.mutex.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok && wait > time.Until(deadline) {
+		bucket.cancel()
+		return RateLimitError
+	}
+	timer, stop := newClockTimer(bucket.clock, wait)
+	defer stop()
+	select {
+	case <-timer:
+		return nil
+	case <-ctx.Done():
+		bucket.cancel()
+		return ctx.Err()
+	}
+}
+
+//归还预占的令牌
+func (bucket *tokenBucket) cancel() {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.tokens++
+}
+
+//漏桶，请求按固定间隔流出，超出队列长度的请求直接拒绝
+type leakyBucket struct {
+	mutex sync.Mutex
+
+	//两次流出的间隔
+	interval time.Duration
+
+	//排队等待的最大请求数
+	capacity int
+
+	//下一个请求可以流出的时间
+	next time.Time
+
+	//时钟
+	clock Clock
+}
+
+//NewLeakyBucket 创建漏桶，每秒流出rate个请求，最多capacity个请求排队等待
+//rate需要大于0且流出间隔不小于1纳秒，capacity为负数时返回InvalidSettingError
+func NewLeakyBucket(rate float64, capacity int) (*leakyBucket, error) {
+	if !(rate > 0) || math.IsInf(rate, 0) {
+		return nil, fmt.Errorf("%w: leaky bucket rate %g must be a positive finite number", InvalidSettingError, rate)
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	if interval <= 0 {
+		return nil, fmt.Errorf("%w: leaky bucket rate %g exceeds one request per nanosecond", InvalidSettingError, rate)
+	}
+	if capacity < 0 {
+		return nil, fmt.Errorf("%w: leaky bucket capacity %d must not be negative", InvalidSettingError, capacity)
+	}
+	return &leakyBucket{
+		interval: interval,
+		capacity: capacity,
+		clock:    DefaultClock,
+	}, nil
+}
+
+//SetClock 设置时钟
+func (bucket *leakyBucket) SetClock(clock Clock) *leakyBucket {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.clock = clock
+	return bucket
+}
+
+//Allow 当前可以流出时返回true，不排队
+func (bucket *leakyBucket) Allow() bool {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	now := bucket.clock.Now()
+	if bucket.next.After(now) {
+		return false
+	}
+	bucket.next = now.Add(bucket.interval)
+	return true
+}
+
+//Wait 排队等待流出，队列已满或ctx在轮到之前结束时返回错误，ctx提前结束时归还预占的位置
+func (bucket *leakyBucket) Wait(ctx context.Context) error {
+	bucket.mutex.Lock()
+	now := bucket.clock.Now()
+	if bucket.next.Before(now) {
+		bucket.next = now
+	}
+	wait := bucket.next.Sub(now)
+	if int(wait/bucket.interval) > bucket.capacity {
+		bucket.mutex.Unlock()
+		return RateLimitError
+	}
+	if deadline, ok := ctx.Deadline(); ok && wait > time.Until(deadline) {
+		bucket.mutex.Unlock()
+		return RateLimitError
+	}
+	bucket.next = bucket.next.Add(bucket.interval)
+	bucket.mutex.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer, stop := newClockTimer(bucket.clock, wait)
+	defer stop()
+	select {
+	case <-timer:
+		return nil
+	case <-ctx.Done():
+		bucket.cancel()
+		return ctx.Err()
+	}
+}
+
+//归还排队时预占的流出时间
+func (bucket *leakyBucket) cancel() {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.next = bucket.next.Add(-bucket.interval)
+}
+
+//带最近使用时间的限流器
+type limiterEntry struct {
+	limiter  Limiter
+	lastUsed time.Time
+}
+
+//按key区分的限流器集合
+type limiterGroup struct {
+	mutex sync.Mutex
+
+	//限流器集合
+	limiters map[string]*limiterEntry
+
+	//创建限流器
+	newLimiter func(key string) Limiter
+
+	//时钟
+	clock Clock
+}
+
+//NewLimiterGroup 创建按key区分的限流器集合，key第一次出现时调用newLimiter创建限流器
+func NewLimiterGroup(newLimiter func(key string) Limiter) *limiterGroup {
+	return &limiterGroup{
+		limiters:   make(map[string]*limiterEntry),
+		newLimiter: newLimiter,
+		clock:      DefaultClock,
+	}
+}
+
+//Get 获取key对应的限流器，不存在时创建
+func (group *limiterGroup) Get(key string) Limiter {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	entry, ok := group.limiters[key]
+	if !ok {
+		entry = &limiterEntry{limiter: group.newLimiter(key)}
+		group.limiters[key] = entry
+	}
+	entry.lastUsed = group.clock.Now()
+	return entry.limiter
+}
+
+//Allow 判断key是否放行
+func (group *limiterGroup) Allow(key string) bool {
+	return group.Get(key).Allow()
+}
+
+//Wait 等待key放行
+func (group *limiterGroup) Wait(ctx context.Context, key string) error {
+	return group.Get(key).Wait(ctx)
+}
+
+//Remove 删除key对应的限流器
+func (group *limiterGroup) Remove(key string) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	delete(group.limiters, key)
+}
+
+//EvictIdle 删除空闲时间超过idleTTL的限流器，返回删除个数
+func (group *limiterGroup) EvictIdle(idleTTL time.Duration) int {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	evicted := 0
+	now := group.clock.Now()
+	for key, entry := range group.limiters {
+		if now.Sub(entry.lastUsed) > idleTTL {
+			delete(group.limiters, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+//DefaultRateLimitFallback 默认限流响应，返回429
+func DefaultRateLimitFallback(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"message": err.Error(),
+	})
+}
+
+//RateLimitMiddleware 使用限流器集合限流，keyFunc返回限流key，为空时按客户端IP限流
+//未放行时调用fallback返回限流响应，未传fallback时使用DefaultRateLimitFallback
+func RateLimitMiddleware(keyFunc func(c *gin.Context) string, group *limiterGroup, fallback ...GinFallbackFunc) gin.HandlerFunc {
+	fallbackFn := DefaultRateLimitFallback
+	if len(fallback) > 0 && fallback[0] != nil {
+		fallbackFn = fallback[0]
+	}
+	return func(c *gin.Context) {
+		key := c.ClientIP()
+		if keyFunc != nil {
+			key = keyFunc(c)
+		}
+		if !group.Allow(key) {
+			fallbackFn(c, RateLimitError)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+//RateLimitWaitMiddleware 与RateLimitMiddleware相同，但未放行的请求按限流器的Wait排队等待
+//请求的ctx结束、漏桶队列已满或等待时间超过ctx的截止时间时调用fallback返回限流响应
+func RateLimitWaitMiddleware(keyFunc func(c *gin.Context) string, group *limiterGroup, fallback ...GinFallbackFunc) gin.HandlerFunc {
+	fallbackFn := DefaultRateLimitFallback
+	if len(fallback) > 0 && fallback[0] != nil {
+		fallbackFn = fallback[0]
+	}
+	return func(c *gin.Context) {
+		key := c.ClientIP()
+		if keyFunc != nil {
+			key = keyFunc(c)
+		}
+		if err := group.Wait(c.Request.Context(), key); err != nil {
+			fallbackFn(c, RateLimitError)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,189 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//等待手动时钟上出现n个定时器，即有n个请求进入等待
+func waitTimers(t *testing.T, clock *ManualClock, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		clock.mutex.RLock()
+		pending := len(clock.timers)
+		clock.mutex.RUnlock()
+		if pending == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pending timers = %d, want %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//在协程中执行Wait并返回结果通道
+func waitAsync(ctx context.Context, limiter Limiter) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- limiter.Wait(ctx)
+	}()
+	return result
+}
+
+//读取Wait结果
+func waitResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return")
+		return nil
+	}
+}
+
+func TestLimiterConstructorsValidate(t *testing.T) {
+	tokenCases := []struct {
+		rate  float64
+		burst int
+	}{
+		{-1, 1}, {math.NaN(), 1}, {math.Inf(1), 1}, {1, 0},
+	}
+	for _, tc := range tokenCases {
+		if _, err := NewTokenBucket(tc.rate, tc.burst); !errors.Is(err, InvalidSettingError) {
+			t.Fatalf("NewTokenBucket(%g, %d) error = %v, want InvalidSettingError", tc.rate, tc.burst, err)
+		}
+	}
+	leakyCases := []struct {
+		rate     float64
+		capacity int
+	}{
+		{0, 1}, {-1, 1}, {math.NaN(), 1}, {math.Inf(1), 1}, {2e9, 1}, {1, -1},
+	}
+	for _, tc := range leakyCases {
+		if _, err := NewLeakyBucket(tc.rate, tc.capacity); !errors.Is(err, InvalidSettingError) {
+			t.Fatalf("NewLeakyBucket(%g, %d) error = %v, want InvalidSettingError", tc.rate, tc.capacity, err)
+		}
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	bucket, err := NewTokenBucket(10, 2)
+	if err != nil {
+		t.Fatalf("NewTokenBucket() error = %v", err)
+	}
+	bucket.SetClock(clock)
+
+	if !bucket.Allow() || !bucket.Allow() || bucket.Allow() {
+		t.Fatal("Allow() should admit exactly burst requests")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !bucket.Allow() || bucket.Allow() {
+		t.Fatal("Allow() should admit one request after 100ms")
+	}
+
+	//Wait按虚拟时间等待令牌生成
+	result := waitAsync(context.Background(), bucket)
+	waitTimers(t, clock, 1)
+	clock.Advance(100 * time.Millisecond)
+	if err := waitResult(t, result); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	//ctx结束时归还预占的令牌
+	ctx, cancel := context.WithCancel(context.Background())
+	result = waitAsync(ctx, bucket)
+	waitTimers(t, clock, 1)
+	cancel()
+	if err := waitResult(t, result); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait() error = %v, want context.Canceled", err)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !bucket.Allow() {
+		t.Fatal("Allow() = false, cancelled Wait kept its token")
+	}
+}
+
+func TestLeakyBucketWait(t *testing.T) {
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	bucket, err := NewLeakyBucket(10, 1)
+	if err != nil {
+		t.Fatalf("NewLeakyBucket() error = %v", err)
+	}
+	bucket.SetClock(clock)
+
+	if !bucket.Allow() || bucket.Allow() {
+		t.Fatal("Allow() should admit one request per interval")
+	}
+
+	//队列只能容纳一个等待的请求
+	ctx, cancel := context.WithCancel(context.Background())
+	first := waitAsync(ctx, bucket)
+	waitTimers(t, clock, 1)
+	if err := bucket.Wait(context.Background()); !errors.Is(err, RateLimitError) {
+		t.Fatalf("Wait() with full queue error = %v, want RateLimitError", err)
+	}
+
+	//取消的请求归还位置，后来的请求可以排队
+	cancel()
+	if err := waitResult(t, first); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait() error = %v, want context.Canceled", err)
+	}
+	second := waitAsync(context.Background(), bucket)
+	waitTimers(t, clock, 1)
+	clock.Advance(100 * time.Millisecond)
+	if err := waitResult(t, second); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+}
+
+func TestRateLimitWaitMiddleware(t *testing.T) {
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	bucket, _ := NewTokenBucket(10, 1)
+	bucket.SetClock(clock)
+	group := NewLimiterGroup(func(key string) Limiter {
+		return bucket
+	})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/limited", RateLimitWaitMiddleware(nil, group), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	serve := func(ctx context.Context) <-chan int {
+		code := make(chan int, 1)
+		go func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/limited", nil).WithContext(ctx))
+			code <- w.Code
+		}()
+		return code
+	}
+
+	if code := <-serve(context.Background()); code != http.StatusOK {
+		t.Fatalf("first code = %d, want 200", code)
+	}
+	//第二个请求排队等待令牌
+	queued := serve(context.Background())
+	waitTimers(t, clock, 1)
+	clock.Advance(100 * time.Millisecond)
+	if code := <-queued; code != http.StatusOK {
+		t.Fatalf("queued code = %d, want 200", code)
+	}
+	//请求结束前未轮到时返回429
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := serve(ctx)
+	waitTimers(t, clock, 1)
+	cancel()
+	if code := <-cancelled; code != http.StatusTooManyRequests {
+		t.Fatalf("cancelled code = %d, want 429", code)
+	}
+}
@@ -0,0 +1,191 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryBaseDelay   = 50 * time.Millisecond
+	DefaultRetryMaxDelay    = time.Second
+	DefaultRetryMultiplier  = 2.0
+	DefaultRetryJitter      = 0.2
+)
+
+//重试预算的统计周期
+const (
+	retryBudgetWindow  = 10 * time.Second
+	retryBudgetGridNum = 10
+)
+
+//重试预算，限制重试次数占请求数的比例，避免下游故障时重试放大流量
+type retryBudget struct {
+	//格子环，total记录请求数，fail记录重试数
+	ring *gridRing
+
+	//允许的重试比例
+	ratio float64
+
+	//统计周期内最少允许的重试数
+	minRetries float64
+
+	//时钟
+	clock Clock
+}
+
+//NewRetryBudget 创建重试预算，统计周期内重试数不超过请求数的percent%，每秒至少允许minRetriesPerSecond次重试
+func NewRetryBudget(percent int, minRetriesPerSecond int) *retryBudget {
+	return &retryBudget{
+		ring:       newGridRing(retryBudgetGridNum, int64(retryBudgetWindow)/retryBudgetGridNum, false),
+		ratio:      float64(percent) / 100,
+		minRetries: float64(minRetriesPerSecond) * retryBudgetWindow.Seconds(),
+		clock:      DefaultClock,
+	}
+}
+
+//SetClock 设置时钟
+func (budget *retryBudget) SetClock(clock Clock) *retryBudget {
+	budget.clock = clock
+	return budget
+}
+
+//记录一次请求
+func (budget *retryBudget) deposit() {
+	if data := budget.ring.current(budget.clock.Now().UnixNano()); data != nil {
+		atomic.AddUint32(&data.total, 1)
+	}
+}
+
+//尝试取得一次重试的预算
+func (budget *retryBudget) withdraw() bool {
+	now := budget.clock.Now().UnixNano()
+	var requests, retries uint32
+	budget.ring.forEach(now, func(data *gridData) {
+		requests += atomic.LoadUint32(&data.total)
+		retries += atomic.LoadUint32(&data.fail)
+	})
+	if float64(retries)+1 > float64(requests)*budget.ratio+budget.minRetries {
+		return false
+	}
+	if data := budget.ring.current(now); data != nil {
+		atomic.AddUint32(&data.fail, 1)
+	}
+	return true
+}
+
+//RetryPolicy 重试策略，零值字段使用默认值
+type RetryPolicy struct {
+	//最大执行次数，包含第一次
+	MaxAttempts int
+
+	//第一次重试前的等待时间
+	BaseDelay time.Duration
+
+	//最大等待时间
+	MaxDelay time.Duration
+
+	//每次重试等待时间的倍数
+	Multiplier float64
+
+	//抖动比例，0到1之间，等待时间在[delay*(1-Jitter), delay]之间随机
+	Jitter float64
+
+	//判断错误是否需要重试，为空时重试熔断器计为失败的错误
+	Retryable func(error) bool
+
+	//重试预算，为空时不限制
+	Budget *retryBudget
+}
+
+//第attempt次重试前的等待时间，attempt从1开始
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	baseDelay, maxDelay, multiplier, jitter := policy.BaseDelay, policy.MaxDelay, policy.Multiplier, policy.Jitter
+	if baseDelay <= 0 {
+		baseDelay = DefaultRetryBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxDelay
+	}
+	if multiplier < 1 {
+		multiplier = DefaultRetryMultiplier
+	}
+	if jitter <= 0 || jitter > 1 {
+		jitter = DefaultRetryJitter
+	}
+	delay := float64(baseDelay) * math.Pow(multiplier, float64(attempt-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	delay -= delay * jitter * rand.Float64()
+	return time.Duration(delay)
+}
+
+//按重试策略执行run，熔断器不再处于关闭状态、错误不可重试、预算不足或ctx结束时停止重试
+func (broker *breaker) retryExec(ctx context.Context, policy *RetryPolicy, run runFunc) execFunc {
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultRetryMaxAttempts
+	}
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = broker.isFail
+	}
+	return func() (time.Duration, error) {
+		if policy.Budget != nil {
+			policy.Budget.deposit()
+		}
+		var duration time.Duration
+		var err error
+		for attempt := 1; ; attempt++ {
+			duration, err = broker.safeRun(run)
+			if err == nil || attempt >= maxAttempts || errors.Is(err, PanicError) || !retryable(err) {
+				return duration, err
+			}
+			//半开启探测和打开时不重试
+			if broker.State() != StatusClosed {
+				return duration, err
+			}
+			if policy.Budget != nil && !policy.Budget.withdraw() {
+				return duration, err
+			}
+			timer := time.NewTimer(policy.backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return duration, err
+			}
+			if broker.State() != StatusClosed {
+				return duration, err
+			}
+			atomic.AddUint64(&broker.metrics.retries, 1)
+		}
+	}
+}
+
+//DoWithRetry 按重试策略结合熔断策略执行run函数，参数与Do相同，policy为空时等同于Do
+//一次调用无论重试多少次，滑动窗口中只按最后一次执行结果计数一次
+func DoWithRetry(ctx context.Context, name string, policy *RetryPolicy, run runFunc, fallback fallbackFunc) error {
+	if policy == nil {
+		return Do(ctx, name, run, fallback)
+	}
+	if run == nil {
+		return FuncNilError
+	}
+	if name == "" {
+		return NameNilError
+	}
+	breaker, err := getBreakerManager(name)
+	if err != nil {
+		if fallback != nil {
+			fallback(err)
+		}
+		return err
+	}
+	return breaker.do(ctx, breaker.retryExec(ctx, policy, run), fallback)
+}
@@ -2,28 +2,70 @@ package breaker
 
 import (
 	"errors"
+	"fmt"
+	"reflect"
+	"time"
 )
 
 var (
-	DefaultInterval                     int64 = 60
-	DefaultSleepWindow                  int64 = 65
-	DefaultBreakerTestMax                     = 20
-	DefaultErrorPercentThreshold              = 50
-	DefaultBreakerErrorPercentThreshold       = 50
+	DefaultInterval                     = 60 * time.Second
+	DefaultSleepWindow                  = 65 * time.Second
+	DefaultGridNum                      = 20
+	DefaultBreakerTestMax               = 20
+	DefaultErrorPercentThreshold        = 50
+	DefaultBreakerErrorPercentThreshold = 50
+	DefaultSlowCallPercent              = 50
+	DefaultMinRequests                  = 10
+	DefaultAdaptiveK                    = 2.0
 )
 
 type breakSettingInfo struct {
 	Name                         string
-	Interval                     int64
-	SleepWindow                  int64
+	Interval                     time.Duration
+	GridNum                      int
+	SleepWindow                  time.Duration
 	BreakerTestMax               int
 	ErrorPercentThreshold        int
 	BreakerErrorPercentThreshold int
+	SlowCallDuration             time.Duration
+	SlowCallPercent              int
+	MinRequests                  int
+
+	//熔断策略，为空时使用错误率熔断策略
+	TripStrategy TripStrategy
+
+	//时钟，为空时使用DefaultClock
+	Clock Clock
+
+	//自适应限流系数K，大于0时使用自适应限流模式代替熔断
+	AdaptiveK float64
+
+	//自适应限流使用的随机数函数，返回[0,1)之间的数，为空时使用rand.Float64
+	Random func() float64
+
+	//舱壁隔离最大并发数，为0时不限制
+	MaxConcurrent int
+
+	//舱壁隔离最大排队数
+	MaxQueue int
+
+	//舱壁隔离排队超时时间
+	QueueTimeout time.Duration
+
+	//判断错误是否计为失败，为空时所有错误都计为失败
+	IsFailure func(error) bool
+
+	//不计为失败的错误列表，使用errors.Is匹配
+	IgnoreErrors []error
 }
 
-//NewBreakSettingInfo 新建熔断器配置
-func NewBreakSettingInfo() *breakSettingInfo {
-	return &breakSettingInfo{}
+//NewBreakSettingInfo 新建熔断器配置，可传入配置项
+func NewBreakSettingInfo(opts ...Option) *breakSettingInfo {
+	brokerSettingInfo := &breakSettingInfo{}
+	for _, opt := range opts {
+		opt(brokerSettingInfo)
+	}
+	return brokerSettingInfo
 }
 
 //SetName 设置策略名
@@ -38,15 +80,28 @@ func (brokerSettingInfo *breakSettingInfo) SetErrorPercentThreshold(errorPercent
 	return brokerSettingInfo
 }
 
-//SetSleepWindow 设置熔断休眠时间
+//SetSleepWindow 设置熔断休眠时间，单位秒
 func (brokerSettingInfo *breakSettingInfo) SetSleepWindow(sleepWindow int64) *breakSettingInfo {
+	brokerSettingInfo.SleepWindow = time.Duration(sleepWindow) * time.Second
+	return brokerSettingInfo
+}
+
+//SetSleepWindowDuration 设置熔断休眠时间，支持毫秒级
+func (brokerSettingInfo *breakSettingInfo) SetSleepWindowDuration(sleepWindow time.Duration) *breakSettingInfo {
 	brokerSettingInfo.SleepWindow = sleepWindow
 	return brokerSettingInfo
 }
 
-//SetInterval 设置采样周期
+//SetInterval 设置采样周期，单位秒
 func (brokerSettingInfo *breakSettingInfo) SetInterval(interval int64) *breakSettingInfo {
+	brokerSettingInfo.Interval = time.Duration(interval) * time.Second
+	return brokerSettingInfo
+}
+
+//SetWindow 设置采样周期及格子数，如10秒分为100个格子，每个格子100毫秒
+func (brokerSettingInfo *breakSettingInfo) SetWindow(interval time.Duration, gridNum int) *breakSettingInfo {
 	brokerSettingInfo.Interval = interval
+	brokerSettingInfo.GridNum = gridNum
 	return brokerSettingInfo
 }
 
@@ -56,29 +111,289 @@ func (brokerSettingInfo *breakSettingInfo) SetBreakerTestMax(breakerTestMax int)
 	return brokerSettingInfo
 }
 
-//AddBreakSetting 添加配置方法，最后将期望配置添加到熔断器管理器里，如果策略名为空字符串则报错
-func (brokerSettingInfo *breakSettingInfo) AddBreakSetting() (*breaker, error) {
-	if brokerSettingInfo.Name == "" {
-		return nil, errors.New("name nil")
+//SetBreakerErrorPercentThreshold 设置半开启状态的错误比，探测请求错误比达到该值时重新打开
+func (brokerSettingInfo *breakSettingInfo) SetBreakerErrorPercentThreshold(breakerErrorPercentThreshold int) *breakSettingInfo {
+	brokerSettingInfo.BreakerErrorPercentThreshold = breakerErrorPercentThreshold
+	return brokerSettingInfo
+}
+
+//SetMinRequests 设置按比例熔断需要的最小请求数
+func (brokerSettingInfo *breakSettingInfo) SetMinRequests(minRequests int) *breakSettingInfo {
+	brokerSettingInfo.MinRequests = minRequests
+	return brokerSettingInfo
+}
+
+//SetTripStrategy 设置熔断策略，可使用NewErrorRateStrategy、NewConsecutiveFailuresStrategy、NewFailureCountStrategy或自定义实现
+func (brokerSettingInfo *breakSettingInfo) SetTripStrategy(tripStrategy TripStrategy) *breakSettingInfo {
+	brokerSettingInfo.TripStrategy = tripStrategy
+	return brokerSettingInfo
+}
+
+//SetSlowCall 设置慢调用熔断，耗时大于等于slowCallDuration的调用占比达到slowCallPercent时熔断
+func (brokerSettingInfo *breakSettingInfo) SetSlowCall(slowCallDuration time.Duration, slowCallPercent int) *breakSettingInfo {
+	brokerSettingInfo.SlowCallDuration = slowCallDuration
+	brokerSettingInfo.SlowCallPercent = slowCallPercent
+	return brokerSettingInfo
+}
+
+//SetClock 设置时钟，测试时可注入ManualClock手动推进时间
+func (brokerSettingInfo *breakSettingInfo) SetClock(clock Clock) *breakSettingInfo {
+	brokerSettingInfo.Clock = clock
+	return brokerSettingInfo
+}
+
+//SetAdaptive 使用Google SRE自适应限流模式代替熔断，k为请求数与成功数的倍数阈值，小于等于0时使用DefaultAdaptiveK
+//该模式下按概率max(0, (requests-k*accepts)/(requests+1))拒绝请求，不再有打开、半开启状态
+func (brokerSettingInfo *breakSettingInfo) SetAdaptive(k float64) *breakSettingInfo {
+	if k <= 0 {
+		k = DefaultAdaptiveK
+	}
+	brokerSettingInfo.AdaptiveK = k
+	return brokerSettingInfo
+}
+
+//SetRandom 设置自适应限流使用的随机数函数，测试时可注入固定序列使拒绝结果确定
+func (brokerSettingInfo *breakSettingInfo) SetRandom(random func() float64) *breakSettingInfo {
+	brokerSettingInfo.Random = random
+	return brokerSettingInfo
+}
+
+//SetBulkhead 设置舱壁隔离，同时执行的请求数超过maxConcurrent时最多maxQueue个请求排队等待queueTimeout
+//排队已满或超时的请求不会执行，fallback收到ErrBulkheadFull，queueTimeout为0时一直等待到ctx结束
+func (brokerSettingInfo *breakSettingInfo) SetBulkhead(maxConcurrent int, maxQueue int, queueTimeout time.Duration) *breakSettingInfo {
+	brokerSettingInfo.MaxConcurrent = maxConcurrent
+	brokerSettingInfo.MaxQueue = maxQueue
+	brokerSettingInfo.QueueTimeout = queueTimeout
+	return brokerSettingInfo
+}
+
+//SetIsFailure 设置失败判定函数，返回false的错误不计入熔断失败
+func (brokerSettingInfo *breakSettingInfo) SetIsFailure(isFailure func(error) bool) *breakSettingInfo {
+	brokerSettingInfo.IsFailure = isFailure
+	return brokerSettingInfo
+}
+
+//SetIgnoreErrors 设置不计入熔断失败的错误，如sql.ErrNoRows等业务错误
+func (brokerSettingInfo *breakSettingInfo) SetIgnoreErrors(errs ...error) *breakSettingInfo {
+	brokerSettingInfo.IgnoreErrors = append(brokerSettingInfo.IgnoreErrors, errs...)
+	return brokerSettingInfo
+}
+
+//复制配置，熔断器保存的配置不受调用方后续修改影响
+func (brokerSettingInfo *breakSettingInfo) clone() *breakSettingInfo {
+	setting := *brokerSettingInfo
+	setting.IgnoreErrors = append([]error(nil), brokerSettingInfo.IgnoreErrors...)
+	return &setting
+}
+
+//InvalidSettingError 熔断器配置不合法
+var InvalidSettingError = errors.New("invalid breaker setting")
+
+//DuplicateNameError 同名熔断器已存在且配置不同
+var DuplicateNameError = errors.New("breaker name already registered with different setting")
+
+//配置中可直接比较的字段，函数及接口类型的字段由sameAs单独比较
+type settingKey struct {
+	Interval                     time.Duration
+	GridNum                      int
+	SleepWindow                  time.Duration
+	BreakerTestMax               int
+	ErrorPercentThreshold        int
+	BreakerErrorPercentThreshold int
+	SlowCallDuration             time.Duration
+	SlowCallPercent              int
+	MinRequests                  int
+	AdaptiveK                    float64
+	MaxConcurrent                int
+	MaxQueue                     int
+	QueueTimeout                 time.Duration
+}
+
+//返回配置中可比较的部分
+func (brokerSettingInfo *breakSettingInfo) key() settingKey {
+	return settingKey{
+		Interval:                     brokerSettingInfo.Interval,
+		GridNum:                      brokerSettingInfo.GridNum,
+		SleepWindow:                  brokerSettingInfo.SleepWindow,
+		BreakerTestMax:               brokerSettingInfo.BreakerTestMax,
+		ErrorPercentThreshold:        brokerSettingInfo.ErrorPercentThreshold,
+		BreakerErrorPercentThreshold: brokerSettingInfo.BreakerErrorPercentThreshold,
+		SlowCallDuration:             brokerSettingInfo.SlowCallDuration,
+		SlowCallPercent:              brokerSettingInfo.SlowCallPercent,
+		MinRequests:                  brokerSettingInfo.MinRequests,
+		AdaptiveK:                    brokerSettingInfo.AdaptiveK,
+		MaxConcurrent:                brokerSettingInfo.MaxConcurrent,
+		MaxQueue:                     brokerSettingInfo.MaxQueue,
+		QueueTimeout:                 brokerSettingInfo.QueueTimeout,
+	}
+}
+
+//判断两个接口值是否相等，动态类型不可比较时视为不相等
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+//判断两份配置是否相同，熔断策略按值比较，时钟及忽略的错误按实例比较
+//函数无法比较，任一配置设置了失败判定或随机数函数时视为不同
+func (brokerSettingInfo *breakSettingInfo) sameAs(other *breakSettingInfo) bool {
+	if brokerSettingInfo.key() != other.key() {
+		return false
+	}
+	if brokerSettingInfo.IsFailure != nil || other.IsFailure != nil ||
+		brokerSettingInfo.Random != nil || other.Random != nil {
+		return false
+	}
+	if !reflect.DeepEqual(brokerSettingInfo.TripStrategy, other.TripStrategy) ||
+		!sameValue(brokerSettingInfo.Clock, other.Clock) {
+		return false
+	}
+	if len(brokerSettingInfo.IgnoreErrors) != len(other.IgnoreErrors) {
+		return false
+	}
+	for idx, err := range brokerSettingInfo.IgnoreErrors {
+		if !sameValue(err, other.IgnoreErrors[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+//返回配置不合法的错误
+func invalidSetting(name string, format string, args ...interface{}) error {
+	return fmt.Errorf("%w %s: %s", InvalidSettingError, name, fmt.Sprintf(format, args...))
+}
+
+//校验配置，零值表示使用默认值，负数及超出范围的值返回错误
+func (brokerSettingInfo *breakSettingInfo) validate() error {
+	name := brokerSettingInfo.Name
+	if name == "" {
+		return NameNilError
 	}
-	if brokerSettingInfo.BreakerErrorPercentThreshold <= 0 {
+	durations := []struct {
+		field string
+		value time.Duration
+	}{
+		{"Interval", brokerSettingInfo.Interval},
+		{"SleepWindow", brokerSettingInfo.SleepWindow},
+		{"SlowCallDuration", brokerSettingInfo.SlowCallDuration},
+		{"QueueTimeout", brokerSettingInfo.QueueTimeout},
+	}
+	for _, duration := range durations {
+		if duration.value < 0 {
+			return invalidSetting(name, "%s %s must not be negative", duration.field, duration.value)
+		}
+	}
+	counts := []struct {
+		field string
+		value int
+	}{
+		{"GridNum", brokerSettingInfo.GridNum},
+		{"BreakerTestMax", brokerSettingInfo.BreakerTestMax},
+		{"MinRequests", brokerSettingInfo.MinRequests},
+		{"MaxConcurrent", brokerSettingInfo.MaxConcurrent},
+		{"MaxQueue", brokerSettingInfo.MaxQueue},
+	}
+	for _, count := range counts {
+		if count.value < 0 {
+			return invalidSetting(name, "%s %d must not be negative", count.field, count.value)
+		}
+	}
+	percents := []struct {
+		field string
+		value int
+	}{
+		{"ErrorPercentThreshold", brokerSettingInfo.ErrorPercentThreshold},
+		{"BreakerErrorPercentThreshold", brokerSettingInfo.BreakerErrorPercentThreshold},
+		{"SlowCallPercent", brokerSettingInfo.SlowCallPercent},
+	}
+	for _, percent := range percents {
+		if percent.value < 0 || percent.value > 100 {
+			return invalidSetting(name, "%s %d out of range [0, 100]", percent.field, percent.value)
+		}
+	}
+	if brokerSettingInfo.AdaptiveK < 0 {
+		return invalidSetting(name, "AdaptiveK %g must not be negative", brokerSettingInfo.AdaptiveK)
+	}
+	if brokerSettingInfo.MaxConcurrent == 0 && (brokerSettingInfo.MaxQueue > 0 || brokerSettingInfo.QueueTimeout > 0) {
+		return invalidSetting(name, "MaxQueue and QueueTimeout require MaxConcurrent")
+	}
+	if brokerSettingInfo.SlowCallDuration == 0 && brokerSettingInfo.SlowCallPercent > 0 {
+		return invalidSetting(name, "SlowCallPercent requires SlowCallDuration")
+	}
+	return nil
+}
+
+//校验配置并填充默认值后创建熔断器，不注册到管理器
+func (brokerSettingInfo *breakSettingInfo) build() (*breaker, error) {
+	if err := brokerSettingInfo.validate(); err != nil {
+		return nil, err
+	}
+	if brokerSettingInfo.BreakerErrorPercentThreshold == 0 {
 		brokerSettingInfo.BreakerErrorPercentThreshold = DefaultBreakerErrorPercentThreshold
 	}
-	if brokerSettingInfo.ErrorPercentThreshold <= 0 {
+	if brokerSettingInfo.ErrorPercentThreshold == 0 {
 		brokerSettingInfo.ErrorPercentThreshold = DefaultErrorPercentThreshold
 	}
-	if brokerSettingInfo.BreakerTestMax < DefaultBreakerTestMax {
+	if brokerSettingInfo.Clock == nil {
+		brokerSettingInfo.Clock = DefaultClock
+	}
+	if brokerSettingInfo.MinRequests == 0 {
+		brokerSettingInfo.MinRequests = DefaultMinRequests
+	}
+	if brokerSettingInfo.SlowCallDuration > 0 && brokerSettingInfo.SlowCallPercent == 0 {
+		brokerSettingInfo.SlowCallPercent = DefaultSlowCallPercent
+	}
+	if brokerSettingInfo.BreakerTestMax == 0 {
 		brokerSettingInfo.BreakerTestMax = DefaultBreakerTestMax
 	}
-	if brokerSettingInfo.Interval < DefaultInterval {
+	if brokerSettingInfo.Interval == 0 {
 		brokerSettingInfo.Interval = DefaultInterval
 	}
-	if brokerSettingInfo.SleepWindow < DefaultInterval {
+	if brokerSettingInfo.GridNum == 0 {
+		brokerSettingInfo.GridNum = DefaultGridNum
+	}
+	if brokerSettingInfo.SleepWindow == 0 {
 		brokerSettingInfo.SleepWindow = DefaultSleepWindow
 	}
-	if brokerSettingInfo.Interval%int64(gridNum) != 0 {
-		return nil, errors.New("interval must 20`s multiple")
+	if brokerSettingInfo.Interval%time.Duration(brokerSettingInfo.GridNum) != 0 {
+		return nil, invalidSetting(brokerSettingInfo.Name, "Interval %s must be a multiple of GridNum %d",
+			brokerSettingInfo.Interval, brokerSettingInfo.GridNum)
+	}
+	if brokerSettingInfo.Interval/time.Duration(brokerSettingInfo.GridNum) < time.Millisecond {
+		return nil, invalidSetting(brokerSettingInfo.Name, "grid time %s must be at least 1ms",
+			brokerSettingInfo.Interval/time.Duration(brokerSettingInfo.GridNum))
+	}
+	return newBreaker(brokerSettingInfo), nil
+}
+
+//注册熔断器，同名熔断器已存在时配置相同则返回已有的熔断器，否则返回DuplicateNameError
+//设置了失败判定或随机数函数的配置无法比较，同名熔断器已存在时总是返回DuplicateNameError
+func (breakerManager *breakerManager) register(broker *breaker) (*breaker, error) {
+	breakerManager.mutex.Lock()
+	defer breakerManager.mutex.Unlock()
+	if old, ok := breakerManager.manager[broker.name]; ok {
+		if old.setting.sameAs(broker.setting) {
+			return old, nil
+		}
+		return nil, fmt.Errorf("%w: %s", DuplicateNameError, broker.name)
+	}
+	breakerManager.manager[broker.name] = broker
+	return broker, nil
+}
+
+//AddBreakSetting 校验配置并添加到熔断器管理器里，策略名为空或配置不合法时返回错误
+//零值字段使用默认值，同名熔断器已存在时配置相同则返回已有的熔断器，配置不同则返回DuplicateNameError
+//熔断策略按值比较，忽略的错误按实例比较，设置了失败判定或随机数函数时无法确认配置相同，同样返回DuplicateNameError
+func (brokerSettingInfo *breakSettingInfo) AddBreakSetting() (*breaker, error) {
+	broker, err := brokerSettingInfo.build()
+	if err != nil {
+		return nil, err
 	}
-	breaker := newBreaker(brokerSettingInfo)
-	return breaker, nil
+	return bm.register(broker)
 }
@@ -1,31 +1,109 @@
 package breaker
 
 import (
-	"sync"
+	"fmt"
 	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
-//Counter 滑动窗口中的计数器
-type Counter struct {
-	val uint32
+//格子数据，格子过期时整体替换而不是清零，避免清零与计数之间的竞争
+type gridData struct {
+	//格子序号，即时间除以格子时间
+	epoch int64
 
-	timeStamp int64
+	//全部请求数
+	total uint32
+
+	//失败请求数
+	fail uint32
+
+	//慢调用请求数
+	slow uint32
+
+	//请求总耗时，单位纳秒
+	duration int64
+
+	//自适应限流拒绝的请求数，不计入全部请求数及失败数
+	rejected uint32
+
+	//耗时直方图，只有记录耗时直方图的格子环才分配
+	buckets *[latencyBuckets]uint32
+}
+
+//格子环，按时间滚动复用格子，所有操作均为原子操作
+type gridRing struct {
+	//格子时间，单位纳秒
+	gridTime int64
+
+	//格子，元素为*gridData
+	grids []unsafe.Pointer
+
+	//是否记录耗时直方图，过载保护及重试预算不读取直方图，不分配
+	latency bool
+}
+
+//创建格子环，latency为true时每个格子分配耗时直方图
+func newGridRing(gridNum int, gridTime int64, latency bool) *gridRing {
+	grids := make([]unsafe.Pointer, gridNum)
+	for idx := range grids {
+		grids[idx] = unsafe.Pointer(&gridData{epoch: -1})
+	}
+	return &gridRing{gridTime: gridTime, grids: grids, latency: latency}
+}
+
+//创建指定序号的空格子
+func (ring *gridRing) newGrid(epoch int64) *gridData {
+	data := &gridData{epoch: epoch}
+	if ring.latency {
+		data.buckets = new([latencyBuckets]uint32)
+	}
+	return data
+}
+
+//获取now所在的格子，格子过期时替换为新格子，now早于格子时间时返回nil
+func (ring *gridRing) current(now int64) *gridData {
+	epoch := now / ring.gridTime
+	grid := &ring.grids[epoch%int64(len(ring.grids))]
+	for {
+		old := atomic.LoadPointer(grid)
+		data := (*gridData)(old)
+		if data.epoch == epoch {
+			return data
+		}
+		if data.epoch > epoch {
+			return nil
+		}
+		if atomic.CompareAndSwapPointer(grid, old, unsafe.Pointer(ring.newGrid(epoch))) {
+			return (*gridData)(atomic.LoadPointer(grid))
+		}
+	}
+}
+
+//遍历now所在窗口内未过期的格子
+func (ring *gridRing) forEach(now int64, fn func(data *gridData)) {
+	epoch := now / ring.gridTime
+	for idx := range ring.grids {
+		data := (*gridData)(atomic.LoadPointer(&ring.grids[idx]))
+		if data.epoch > epoch-int64(len(ring.grids)) && data.epoch <= epoch {
+			fn(data)
+		}
+	}
 }
 
 //SlidingWindow 滑动窗口
 type SlidingWindow struct {
-	//滑动窗口周期
+	//滑动窗口周期，单位纳秒
 	windowSize int64
 
-	//全部请求的计数器
-	allRequestCounter []*Counter
+	//格子数
+	gridNum int
 
-	//失败请求的计数器
-	failRequestCounter []*Counter
+	//格子时间，单位纳秒
+	gridTime int64
 
-	//熔断器关闭状态的计数通道
-	closeCountChan chan bool
+	//格子环
+	ring *gridRing
 
 	//半开启请求数
 	halfOpenReqNum int32
@@ -36,18 +114,30 @@ type SlidingWindow struct {
 	//熔断恢复需要的请求个数
 	recoverNum int32
 
-	//开始时间
-	startTime int64
+	//熔断策略
+	tripStrategy TripStrategy
 
-	//格子时间
-	gridTime int64
+	//连续失败数
+	consecutiveFailures uint32
 
-	//错误率
-	errorPercent int
+	//慢调用熔断需要的最小请求数
+	minRequests uint32
 
 	//半开启错误率
 	halfOpenErrorPercent int
 
+	//慢调用时长阈值，为0时不统计慢调用
+	slowCallDuration time.Duration
+
+	//慢调用比例
+	slowCallPercent int
+
+	//时钟
+	clock Clock
+
+	//只统计不熔断
+	statOnly bool
+
 	//状态
 	status int32
 }
@@ -56,190 +146,169 @@ const (
 	StatusClosed int32 = iota
 
 	StatusOpen
+
+	//StatusHalfOpen 半开启状态，滑动窗口只记录关闭和打开，半开启由熔断器根据休眠时间计算
+	StatusHalfOpen
 )
 
-var gridNum = 20
+//StatusName 返回状态名
+func StatusName(status int32) string {
+	switch status {
+	case StatusClosed:
+		return "closed"
+	case StatusOpen:
+		return "open"
+	case StatusHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
 
 //SlidingWindowSetting 滑动窗口设置
 type SlidingWindowSetting struct {
-	//周期
-	CycleTime int64
+	//周期，需大于0，旧版本为int64秒，现为time.Duration，原来传入的10需改为10 * time.Second
+	CycleTime time.Duration
+
+	//格子数，为0时使用DefaultGridNum，周期需为格子数的整数倍
+	GridNum int
 
-	//错误率
+	//错误率，TripStrategy为空时使用错误率熔断策略
 	ErrorPercent int
 
+	//熔断策略
+	TripStrategy TripStrategy
+
+	//最小请求数，窗口内请求数少于该值时不按比例熔断
+	MinRequests int
+
 	//半开启错误率
 	HalfOpenErrorPercent int
 
 	//熔断恢复需要的请求个数
 	RecoverNum int
-}
 
-//消费资源
-func (slidingWindow *SlidingWindow) consumeRes() {
-	for {
-		select {
-		case res := <-slidingWindow.closeCountChan:
-			if res {
-				slidingWindow.add()
-			} else {
-				slidingWindow.addFail()
-			}
-		}
-	}
-}
+	//慢调用时长阈值，耗时大于等于该值的调用计为慢调用，为0时关闭慢调用熔断
+	SlowCallDuration time.Duration
 
-//成功时的计数方法，因为加锁所以默认所有请求是有时序性的
-func (slidingWindow *SlidingWindow) add() {
-	addTime := time.Now().Local().Unix()
-	diffTime := addTime - slidingWindow.startTime
-	if diffTime >= slidingWindow.windowSize {
-		slidingWindow.startTime = addTime
-		diffTime = 0
-	}
-	index := diffTime / slidingWindow.gridTime
+	//慢调用比例，慢调用占比达到该值时熔断
+	SlowCallPercent int
 
-	if addTime-slidingWindow.allRequestCounter[index].timeStamp >= slidingWindow.windowSize {
-		slidingWindow.allRequestCounter[index].val = 1
-		slidingWindow.allRequestCounter[index].timeStamp = addTime
-		return
-	}
-	slidingWindow.allRequestCounter[index].val++
+	//时钟，为空时使用DefaultClock
+	Clock Clock
+
+	//只统计不熔断，用于自适应限流等由熔断器自行决策的模式
+	StatOnly bool
 }
 
-//失败时的计数方法
-func (slidingWindow *SlidingWindow) addFail() {
-	addTime := time.Now().Local().Unix()
-	diffTime := addTime - slidingWindow.startTime
-	if diffTime >= slidingWindow.windowSize {
-		slidingWindow.startTime = addTime
-		diffTime = 0
+//计数并判断是否需要熔断，本次计数使状态由关闭变为打开时返回true
+func (slidingWindow *SlidingWindow) add(res bool, duration time.Duration) bool {
+	data := slidingWindow.ring.current(slidingWindow.clock.Now().UnixNano())
+	if data == nil {
+		return false
+	}
+	atomic.AddUint32(&data.total, 1)
+	atomic.AddInt64(&data.duration, int64(duration))
+	if data.buckets != nil {
+		atomic.AddUint32(&data.buckets[latencyBucket(duration)], 1)
+	}
+	slow := slidingWindow.IsSlow(duration)
+	if slow {
+		atomic.AddUint32(&data.slow, 1)
+	}
+	if res {
+		atomic.StoreUint32(&slidingWindow.consecutiveFailures, 0)
+	} else {
+		atomic.AddUint32(&data.fail, 1)
+		atomic.AddUint32(&slidingWindow.consecutiveFailures, 1)
+	}
+	if slidingWindow.statOnly || (!slow && res) {
+		return false
 	}
-	loc := diffTime / slidingWindow.gridTime
-
-	var wg sync.WaitGroup
-	//请求计数
-	wg.Add(1)
-	go func(wg *sync.WaitGroup) {
-		defer wg.Done()
-		if addTime-slidingWindow.allRequestCounter[loc].timeStamp >= slidingWindow.windowSize {
-			slidingWindow.allRequestCounter[loc].val = 1
-			slidingWindow.allRequestCounter[loc].timeStamp = addTime
-			return
-		}
-		slidingWindow.allRequestCounter[loc].val++
-	}(&wg)
-
-	//失败计数
-	wg.Add(1)
-	go func(wg *sync.WaitGroup) {
-		defer wg.Done()
-		if addTime-slidingWindow.failRequestCounter[loc].timeStamp >= slidingWindow.windowSize {
-			slidingWindow.failRequestCounter[loc].val = 1
-			slidingWindow.failRequestCounter[loc].timeStamp = addTime
-			return
-		}
-		slidingWindow.failRequestCounter[loc].val++
-	}(&wg)
-
-	wg.Wait()
 
-	percent, ok := slidingWindow.getFailPercentThreshold()
-	if !ok {
-		return
+	stat := slidingWindow.GetWindowStat()
+	if !res && slidingWindow.tripStrategy.ShouldTrip(stat) {
+		return atomic.CompareAndSwapInt32(&slidingWindow.status, StatusClosed, StatusOpen)
 	}
-	if percent >= slidingWindow.errorPercent {
-		atomic.StoreInt32(&slidingWindow.status, StatusOpen)
+	if slow && stat.Total >= slidingWindow.minRequests && stat.SlowPercent() >= slidingWindow.slowCallPercent {
+		return atomic.CompareAndSwapInt32(&slidingWindow.status, StatusClosed, StatusOpen)
 	}
+	return false
 }
 
-//计算错误率
-func (slidingWindow *SlidingWindow) getFailPercentThreshold() (int, bool) {
-	bucket := time.Now().Local().Unix() - slidingWindow.windowSize
-
-	var totalCount uint32 = 0
-	var totalFailedCount uint32 = 0
-	totalCountChan := make(chan uint32)
-	totalFailedCountChan := make(chan uint32)
-	go func() {
-		var totalClosedCount uint32 = 0
-		for _, count := range slidingWindow.allRequestCounter {
-			if count.timeStamp <= bucket {
-				continue
-			}
-			totalClosedCount += count.val
-		}
-		totalCountChan <- totalClosedCount
-	}()
-
-	go func() {
-		var totalFailedCount uint32 = 0
-		for _, count := range slidingWindow.failRequestCounter {
-			if count.timeStamp <= bucket {
-				continue
-			}
-			totalFailedCount += count.val
-
-		}
-		totalFailedCountChan <- totalFailedCount
-	}()
+//GetWindowStat 统计窗口内的请求数、失败数及慢调用数
+func (slidingWindow *SlidingWindow) GetWindowStat() WindowStat {
+	stat := WindowStat{ConsecutiveFailures: atomic.LoadUint32(&slidingWindow.consecutiveFailures)}
+	slidingWindow.ring.forEach(slidingWindow.clock.Now().UnixNano(), func(data *gridData) {
+		stat.Total += atomic.LoadUint32(&data.total)
+		stat.Failed += atomic.LoadUint32(&data.fail)
+		stat.Slow += atomic.LoadUint32(&data.slow)
+		stat.Rejected += atomic.LoadUint32(&data.rejected)
+	})
+	return stat
+}
 
-	frequency := 0
-loop:
-	for {
-		select {
-		case totalCount = <-totalCountChan:
-			frequency++
-			if frequency >= 2 {
-				break loop
-			}
-		case totalFailedCount = <-totalFailedCountChan:
-			frequency++
-			if frequency >= 2 {
-				break loop
-			}
-		}
+//NewSlidingWindow 创建一个滑动窗口，GridNum为0时使用DefaultGridNum
+//CycleTime不大于0、GridNum为负数或CycleTime不是GridNum的整数倍时返回InvalidSettingError
+func NewSlidingWindow(slidingWindowSetting SlidingWindowSetting) (*SlidingWindow, error) {
+	if slidingWindowSetting.GridNum == 0 {
+		slidingWindowSetting.GridNum = DefaultGridNum
 	}
-	if totalCount < 10 {
-		return 0, false
+	if slidingWindowSetting.CycleTime <= 0 {
+		return nil, fmt.Errorf("%w: sliding window cycle time %s must be positive", InvalidSettingError, slidingWindowSetting.CycleTime)
 	}
-	return int(float32(totalFailedCount)/float32(totalCount)*100 + 0.5), true
-}
-
-//NewSlidingWindow 创建一个滑动窗口
-func NewSlidingWindow(slidingWindowSetting SlidingWindowSetting) *SlidingWindow {
-	var allRequestCounter []*Counter
-	var failRequestCounter []*Counter
-	for idx := 0; idx < gridNum; idx++ {
-		counter := &Counter{}
-		counter.val = 0
-		counter.timeStamp = 0
-		allRequestCounter = append(allRequestCounter, counter)
+	if slidingWindowSetting.GridNum < 0 {
+		return nil, fmt.Errorf("%w: sliding window grid num %d must be positive", InvalidSettingError, slidingWindowSetting.GridNum)
 	}
-	for idx := 0; idx < gridNum; idx++ {
-		counter := &Counter{}
-		counter.val = 0
-		counter.timeStamp = 0
-		failRequestCounter = append(failRequestCounter, counter)
+	if slidingWindowSetting.CycleTime%time.Duration(slidingWindowSetting.GridNum) != 0 {
+		return nil, fmt.Errorf("%w: sliding window cycle time %s must be a multiple of grid num %d",
+			InvalidSettingError, slidingWindowSetting.CycleTime, slidingWindowSetting.GridNum)
 	}
+	return newSlidingWindow(slidingWindowSetting), nil
+}
+
+//创建滑动窗口，调用方需保证设置已校验
+func newSlidingWindow(slidingWindowSetting SlidingWindowSetting) *SlidingWindow {
+	gridTime := int64(slidingWindowSetting.CycleTime) / int64(slidingWindowSetting.GridNum)
 	slidingWindow := &SlidingWindow{
-		windowSize:           slidingWindowSetting.CycleTime,
-		gridTime:             slidingWindowSetting.CycleTime / int64(gridNum),
-		allRequestCounter:    allRequestCounter,
-		failRequestCounter:   failRequestCounter,
-		startTime:            0,
-		closeCountChan:       make(chan bool, 10000),
-		errorPercent:         slidingWindowSetting.ErrorPercent,
+		windowSize:           int64(slidingWindowSetting.CycleTime),
+		gridNum:              slidingWindowSetting.GridNum,
+		gridTime:             gridTime,
+		ring:                 newGridRing(slidingWindowSetting.GridNum, gridTime, true),
+		tripStrategy:         slidingWindowSetting.TripStrategy,
+		minRequests:          uint32(slidingWindowSetting.MinRequests),
 		halfOpenErrorPercent: slidingWindowSetting.HalfOpenErrorPercent,
 		recoverNum:           int32(slidingWindowSetting.RecoverNum),
+		slowCallDuration:     slidingWindowSetting.SlowCallDuration,
+		slowCallPercent:      slidingWindowSetting.SlowCallPercent,
+		clock:                slidingWindowSetting.Clock,
+		statOnly:             slidingWindowSetting.StatOnly,
+	}
+	if slidingWindow.clock == nil {
+		slidingWindow.clock = DefaultClock
+	}
+	if slidingWindow.tripStrategy == nil {
+		slidingWindow.tripStrategy = NewErrorRateStrategy(slidingWindowSetting.ErrorPercent, slidingWindowSetting.MinRequests)
 	}
-	go slidingWindow.consumeRes()
 	return slidingWindow
 }
 
-//AddForClose 记录关闭状态的数量
-func (slidingWindow *SlidingWindow) AddForClose(res bool) {
-	slidingWindow.closeCountChan <- res
+//AddForClose 记录关闭状态的数量及调用耗时，本次计数触发熔断时返回true
+func (slidingWindow *SlidingWindow) AddForClose(res bool, duration time.Duration) bool {
+	return slidingWindow.add(res, duration)
+}
+
+//AddRejected 记录自适应限流拒绝的请求，只计入拒绝数，不影响失败数、连续失败数及耗时直方图
+func (slidingWindow *SlidingWindow) AddRejected() {
+	data := slidingWindow.ring.current(slidingWindow.clock.Now().UnixNano())
+	if data == nil {
+		return
+	}
+	atomic.AddUint32(&data.rejected, 1)
+}
+
+//IsSlow 判断调用耗时是否为慢调用
+func (slidingWindow *SlidingWindow) IsSlow(duration time.Duration) bool {
+	return slidingWindow.slowCallDuration > 0 && duration >= slidingWindow.slowCallDuration
 }
 
 //AddForOpen 记录开启状态的数量
@@ -276,6 +345,7 @@ func (slidingWindow *SlidingWindow) AddForOpen(res bool) bool {
 func (slidingWindow *SlidingWindow) clear() {
 	atomic.StoreInt32(&slidingWindow.halfOpenReqNum, 0)
 	atomic.StoreInt32(&slidingWindow.halfOpenFailReqNum, 0)
+	atomic.StoreUint32(&slidingWindow.consecutiveFailures, 0)
 }
 
 //GetStatus 获取状态
@@ -0,0 +1,370 @@
+package breaker
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestSlidingWindow(clock Clock) *SlidingWindow {
+	return newSlidingWindow(SlidingWindowSetting{
+		CycleTime:            10 * time.Second,
+		GridNum:              10,
+		ErrorPercent:         101,
+		MinRequests:          DefaultMinRequests,
+		HalfOpenErrorPercent: DefaultBreakerErrorPercentThreshold,
+		RecoverNum:           DefaultBreakerTestMax,
+		SlowCallDuration:     100 * time.Millisecond,
+		SlowCallPercent:      101,
+		Clock:                clock,
+	})
+}
+
+func TestNewSlidingWindowValidates(t *testing.T) {
+	//未设置格子数时使用默认值
+	window, err := NewSlidingWindow(SlidingWindowSetting{CycleTime: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewSlidingWindow() error = %v", err)
+	}
+	if window.gridNum != DefaultGridNum || window.gridTime != int64(10*time.Second)/int64(DefaultGridNum) {
+		t.Fatalf("grid num = %d, grid time = %d, want %d grids", window.gridNum, window.gridTime, DefaultGridNum)
+	}
+	window.AddForClose(false, 0)
+	if stat := window.GetWindowStat(); stat.Total != 1 || stat.Failed != 1 {
+		t.Fatalf("window stat = %+v, want total 1, failed 1", stat)
+	}
+
+	invalid := []SlidingWindowSetting{
+		{},
+		{CycleTime: -time.Second},
+		{CycleTime: 10 * time.Second, GridNum: -1},
+		{CycleTime: 10 * time.Second, GridNum: 3},
+		//旧版本以秒为单位的周期
+		{CycleTime: 10},
+	}
+	for _, setting := range invalid {
+		if _, err := NewSlidingWindow(setting); !errors.Is(err, InvalidSettingError) {
+			t.Fatalf("NewSlidingWindow(%+v) error = %v, want InvalidSettingError", setting, err)
+		}
+	}
+}
+
+func TestSlidingWindowConcurrentCounts(t *testing.T) {
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	window := newTestSlidingWindow(clock)
+
+	const goroutines, calls = 16, 5000
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				window.AddForClose(j%2 == 0, time.Duration(j%4)*50*time.Millisecond)
+				_ = window.GetWindowStat()
+				_ = window.GetStatus()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stat := window.GetWindowStat()
+	if stat.Total != goroutines*calls {
+		t.Fatalf("Total = %d, want %d", stat.Total, goroutines*calls)
+	}
+	if stat.Failed != goroutines*calls/2 {
+		t.Fatalf("Failed = %d, want %d", stat.Failed, goroutines*calls/2)
+	}
+	if stat.Slow != goroutines*calls/2 {
+		t.Fatalf("Slow = %d, want %d", stat.Slow, goroutines*calls/2)
+	}
+}
+
+func TestSlidingWindowConcurrentRollover(t *testing.T) {
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	window := newTestSlidingWindow(clock)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				clock.Advance(time.Millisecond)
+			}
+		}
+	}()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				window.AddForClose(false, 0)
+				window.GetWindowStat()
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	clock.Advance(11 * time.Second)
+	if stat := window.GetWindowStat(); stat.Total != 0 {
+		t.Fatalf("Total after window = %d, want 0", stat.Total)
+	}
+}
+
+func TestSlidingWindowLatencyPercentiles(t *testing.T) {
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	window := newTestSlidingWindow(clock)
+	if got := window.Percentile(0.5); got != 0 {
+		t.Fatalf("empty Percentile(0.5) = %v, want 0", got)
+	}
+	for i := 0; i < 90; i++ {
+		window.AddForClose(true, 10*time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		window.AddForClose(true, 500*time.Millisecond)
+	}
+
+	//直方图格子误差不超过约19%
+	within := func(got, want time.Duration) bool {
+		return got >= want*8/10 && got <= want*12/10
+	}
+	stat := window.GetLatencyStat()
+	if stat.Count != 100 || !within(stat.P50, 10*time.Millisecond) || !within(stat.P99, 500*time.Millisecond) {
+		t.Fatalf("latency = %+v, want count 100, p50 ~10ms, p99 ~500ms", stat)
+	}
+
+	//窗口滑过后旧格子的耗时不再参与统计
+	clock.Advance(11 * time.Second)
+	if got := window.GetLatencyStat().Count; got != 0 {
+		t.Fatalf("Count after window = %d, want 0", got)
+	}
+}
+
+func TestGridRingLatencyAllocation(t *testing.T) {
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	now := clock.Now().UnixNano()
+	if data := newTestSlidingWindow(clock).ring.current(now); data.buckets == nil {
+		t.Fatal("sliding window grid has no latency buckets")
+	}
+
+	//过载保护及重试预算不读取直方图，格子不分配
+	limiter := NewBBRLimiter(BBRSetting{Clock: clock, CPUUsage: func() int64 {
+		return 0
+	}})
+	if data := limiter.ring.current(now); data.buckets != nil {
+		t.Fatal("bbr grid has latency buckets")
+	}
+	if data := NewRetryBudget(10, 1).ring.current(now); data.buckets != nil {
+		t.Fatal("retry budget grid has latency buckets")
+	}
+}
+
+//旧版基于通道的滑动窗口，复制自改写前的实现，仅作为基准测试对照
+//每次计数由单个消费协程处理，失败计数时再启动两个协程更新格子并通过通道汇总错误率
+type baselineCounter struct {
+	val uint32
+
+	timeStamp int64
+}
+
+type baselineWindow struct {
+	windowSize int64
+
+	allRequestCounter []*baselineCounter
+
+	failRequestCounter []*baselineCounter
+
+	closeCountChan chan bool
+
+	startTime int64
+
+	gridTime int64
+
+	errorPercent int
+
+	status int32
+
+	//已处理的计数，基准测试据此等待消费完成
+	consumed int64
+}
+
+func newBaselineWindow(cycleTime int64, errorPercent int) *baselineWindow {
+	const gridNum = 20
+	var allRequestCounter []*baselineCounter
+	var failRequestCounter []*baselineCounter
+	for idx := 0; idx < gridNum; idx++ {
+		allRequestCounter = append(allRequestCounter, &baselineCounter{})
+		failRequestCounter = append(failRequestCounter, &baselineCounter{})
+	}
+	slidingWindow := &baselineWindow{
+		windowSize:         cycleTime,
+		gridTime:           cycleTime / gridNum,
+		allRequestCounter:  allRequestCounter,
+		failRequestCounter: failRequestCounter,
+		closeCountChan:     make(chan bool, 10000),
+		errorPercent:       errorPercent,
+	}
+	go slidingWindow.consumeRes()
+	return slidingWindow
+}
+
+func (slidingWindow *baselineWindow) consumeRes() {
+	for res := range slidingWindow.closeCountChan {
+		if res {
+			slidingWindow.add()
+		} else {
+			slidingWindow.addFail()
+		}
+		atomic.AddInt64(&slidingWindow.consumed, 1)
+	}
+}
+
+func (slidingWindow *baselineWindow) add() {
+	addTime := time.Now().Local().Unix()
+	diffTime := addTime - slidingWindow.startTime
+	if diffTime >= slidingWindow.windowSize {
+		slidingWindow.startTime = addTime
+		diffTime = 0
+	}
+	index := diffTime / slidingWindow.gridTime
+
+	if addTime-slidingWindow.allRequestCounter[index].timeStamp >= slidingWindow.windowSize {
+		slidingWindow.allRequestCounter[index].val = 1
+		slidingWindow.allRequestCounter[index].timeStamp = addTime
+		return
+	}
+	slidingWindow.allRequestCounter[index].val++
+}
+
+func (slidingWindow *baselineWindow) addFail() {
+	addTime := time.Now().Local().Unix()
+	diffTime := addTime - slidingWindow.startTime
+	if diffTime >= slidingWindow.windowSize {
+		slidingWindow.startTime = addTime
+		diffTime = 0
+	}
+	loc := diffTime / slidingWindow.gridTime
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func(wg *sync.WaitGroup) {
+		defer wg.Done()
+		if addTime-slidingWindow.allRequestCounter[loc].timeStamp >= slidingWindow.windowSize {
+			slidingWindow.allRequestCounter[loc].val = 1
+			slidingWindow.allRequestCounter[loc].timeStamp = addTime
+			return
+		}
+		slidingWindow.allRequestCounter[loc].val++
+	}(&wg)
+
+	wg.Add(1)
+	go func(wg *sync.WaitGroup) {
+		defer wg.Done()
+		if addTime-slidingWindow.failRequestCounter[loc].timeStamp >= slidingWindow.windowSize {
+			slidingWindow.failRequestCounter[loc].val = 1
+			slidingWindow.failRequestCounter[loc].timeStamp = addTime
+			return
+		}
+		slidingWindow.failRequestCounter[loc].val++
+	}(&wg)
+
+	wg.Wait()
+
+	percent, ok := slidingWindow.getFailPercentThreshold()
+	if !ok {
+		return
+	}
+	if percent >= slidingWindow.errorPercent {
+		atomic.StoreInt32(&slidingWindow.status, StatusOpen)
+	}
+}
+
+func (slidingWindow *baselineWindow) getFailPercentThreshold() (int, bool) {
+	bucket := time.Now().Local().Unix() - slidingWindow.windowSize
+
+	var totalCount uint32
+	var totalFailedCount uint32
+	totalCountChan := make(chan uint32)
+	totalFailedCountChan := make(chan uint32)
+	go func() {
+		var totalClosedCount uint32
+		for _, count := range slidingWindow.allRequestCounter {
+			if count.timeStamp <= bucket {
+				continue
+			}
+			totalClosedCount += count.val
+		}
+		totalCountChan <- totalClosedCount
+	}()
+
+	go func() {
+		var totalFailedCount uint32
+		for _, count := range slidingWindow.failRequestCounter {
+			if count.timeStamp <= bucket {
+				continue
+			}
+			totalFailedCount += count.val
+		}
+		totalFailedCountChan <- totalFailedCount
+	}()
+
+	for frequency := 0; frequency < 2; frequency++ {
+		select {
+		case totalCount = <-totalCountChan:
+		case totalFailedCount = <-totalFailedCountChan:
+		}
+	}
+	if totalCount < 10 {
+		return 0, false
+	}
+	return int(float32(totalFailedCount)/float32(totalCount)*100 + 0.5), true
+}
+
+func BenchmarkSlidingWindowAddForClose(b *testing.B) {
+	window := newTestSlidingWindow(DefaultClock)
+	b.RunParallel(func(pb *testing.PB) {
+		res := false
+		for pb.Next() {
+			res = !res
+			window.AddForClose(res, 0)
+		}
+	})
+}
+
+//旧版计数在消费协程中异步完成，等待全部计数处理完后再停止计时，与新版同步计数的耗时可比
+func BenchmarkBaselineWindowAddForClose(b *testing.B) {
+	window := newBaselineWindow(60, 101)
+	defer close(window.closeCountChan)
+	b.RunParallel(func(pb *testing.PB) {
+		res := false
+		for pb.Next() {
+			res = !res
+			window.closeCountChan <- res
+		}
+	})
+	for atomic.LoadInt64(&window.consumed) < int64(b.N) {
+		runtime.Gosched()
+	}
+}
+
+func BenchmarkSlidingWindowGetWindowStat(b *testing.B) {
+	window := newTestSlidingWindow(DefaultClock)
+	for i := 0; i < 1000; i++ {
+		window.AddForClose(i%2 == 0, 0)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			window.GetWindowStat()
+		}
+	})
+}
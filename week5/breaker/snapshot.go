@@ -0,0 +1,260 @@
+package breaker
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+//SnapshotStaleError 快照超过最大有效期，不再恢复
+var SnapshotStaleError = errors.New("breaker snapshot stale")
+
+var (
+	DefaultSnapshotInterval = 30 * time.Second
+	DefaultSnapshotMaxAge   = 5 * time.Minute
+)
+
+//GridSnapshot 格子快照
+type GridSnapshot struct {
+	Epoch    int64  `json:"epoch"`
+	Total    uint32 `json:"total"`
+	Fail     uint32 `json:"fail"`
+	Slow     uint32 `json:"slow"`
+	Duration int64  `json:"duration"`
+
+	//自适应限流拒绝的请求数
+	Rejected uint32 `json:"rejected,omitempty"`
+
+	//耗时直方图
+	Buckets []uint32 `json:"buckets,omitempty"`
+}
+
+//BreakerSnapshot 单个熔断器的快照
+type BreakerSnapshot struct {
+	//策略名
+	Name string `json:"name"`
+
+	//状态，只有关闭和打开
+	Status int32 `json:"status"`
+
+	//休眠结束的时间，单位纳秒
+	CycleTime int64 `json:"cycle_time"`
+
+	//人工强制状态
+	Forced int32 `json:"forced"`
+
+	//连续失败数
+	ConsecutiveFailures uint32 `json:"consecutive_failures"`
+
+	//格子时间，单位纳秒，与恢复时的窗口不一致时不恢复格子
+	GridTime int64 `json:"grid_time"`
+
+	//未过期的格子
+	Grids []GridSnapshot `json:"grids"`
+}
+
+//Snapshot 快照文件内容
+type Snapshot struct {
+	//保存时间，单位纳秒
+	SavedAt int64 `json:"saved_at"`
+
+	//熔断器快照
+	Breakers []BreakerSnapshot `json:"breakers"`
+}
+
+//返回熔断器快照
+func (broker *breaker) snapshot() BreakerSnapshot {
+	counter := broker.counter
+	snapshot := BreakerSnapshot{
+		Name:                broker.name,
+		Status:              counter.GetStatus(),
+		CycleTime:           atomic.LoadInt64(&broker.cycleTime),
+		Forced:              atomic.LoadInt32(&broker.forced),
+		ConsecutiveFailures: atomic.LoadUint32(&counter.consecutiveFailures),
+		GridTime:            counter.gridTime,
+	}
+	counter.ring.forEach(broker.clock.Now().UnixNano(), func(data *gridData) {
+		grid := GridSnapshot{
+			Epoch:    data.epoch,
+			Total:    atomic.LoadUint32(&data.total),
+			Fail:     atomic.LoadUint32(&data.fail),
+			Slow:     atomic.LoadUint32(&data.slow),
+			Duration: atomic.LoadInt64(&data.duration),
+			Rejected: atomic.LoadUint32(&data.rejected),
+		}
+		if data.buckets != nil {
+			grid.Buckets = make([]uint32, latencyBuckets)
+			for idx := range grid.Buckets {
+				grid.Buckets[idx] = atomic.LoadUint32(&data.buckets[idx])
+			}
+		}
+		snapshot.Grids = append(snapshot.Grids, grid)
+	})
+	return snapshot
+}
+
+//从快照恢复熔断器，格子时间一致时恢复窗口计数，已过期的格子在统计时自动忽略
+func (broker *breaker) restore(snapshot BreakerSnapshot) {
+	atomic.StoreInt32(&broker.forced, snapshot.Forced)
+	if snapshot.Status == StatusOpen {
+		broker.adoptState(StatusOpen, snapshot.CycleTime, reasonSnapshot)
+	}
+	counter := broker.counter
+	if snapshot.GridTime != counter.gridTime {
+		return
+	}
+	atomic.StoreUint32(&counter.consecutiveFailures, snapshot.ConsecutiveFailures)
+	for _, grid := range snapshot.Grids {
+		//序号为负数的是从未使用过的空格子，靠近Unix 0的时钟下可能出现在快照中
+		if grid.Epoch < 0 {
+			continue
+		}
+		slot := &counter.ring.grids[grid.Epoch%int64(len(counter.ring.grids))]
+		for {
+			old := atomic.LoadPointer(slot)
+			if (*gridData)(old).epoch >= grid.Epoch {
+				break
+			}
+			data := counter.ring.newGrid(grid.Epoch)
+			data.total, data.fail, data.slow, data.duration, data.rejected = grid.Total, grid.Fail, grid.Slow, grid.Duration, grid.Rejected
+			if data.buckets != nil {
+				copy(data.buckets[:], grid.Buckets)
+			}
+			if atomic.CompareAndSwapPointer(slot, old, unsafe.Pointer(data)) {
+				break
+			}
+		}
+	}
+}
+
+//SnapshotSetting 快照设置
+type SnapshotSetting struct {
+	//快照文件路径
+	Path string
+
+	//定时保存间隔
+	Interval time.Duration
+
+	//快照最大有效期，超过后不再恢复
+	MaxAge time.Duration
+
+	//时钟，为空时使用DefaultClock
+	Clock Clock
+}
+
+//熔断器快照，定时及停止时将全部熔断器的状态和窗口计数保存到本地文件，启动时恢复
+type snapshotter struct {
+	//设置
+	setting SnapshotSetting
+
+	//保护stop
+	mutex sync.Mutex
+
+	//停止通道
+	stop chan struct{}
+}
+
+//NewSnapshotter 创建熔断器快照，未设置的字段使用默认值
+func NewSnapshotter(setting SnapshotSetting) *snapshotter {
+	if setting.Interval <= 0 {
+		setting.Interval = DefaultSnapshotInterval
+	}
+	if setting.MaxAge <= 0 {
+		setting.MaxAge = DefaultSnapshotMaxAge
+	}
+	if setting.Clock == nil {
+		setting.Clock = DefaultClock
+	}
+	return &snapshotter{setting: setting}
+}
+
+//Save 保存全部熔断器的快照，先写临时文件再重命名，避免中途退出留下不完整的文件
+func (snapshotter *snapshotter) Save() error {
+	snapshot := Snapshot{SavedAt: snapshotter.setting.Clock.Now().UnixNano()}
+	for _, broker := range bm.list() {
+		snapshot.Breakers = append(snapshot.Breakers, broker.snapshot())
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(snapshotter.setting.Path), 0755); err != nil {
+		return err
+	}
+	tmp := snapshotter.setting.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, snapshotter.setting.Path)
+}
+
+//Restore 从快照文件恢复熔断器，返回恢复的熔断器个数
+//文件不存在时返回0和nil，快照超过MaxAge时返回SnapshotStaleError
+//不存在的熔断器按配置或默认值创建，代码中注册的熔断器需要在Restore之前注册
+func (snapshotter *snapshotter) Restore() (int, error) {
+	data, err := ioutil.ReadFile(snapshotter.setting.Path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, err
+	}
+	if snapshotter.setting.Clock.Now().UnixNano()-snapshot.SavedAt > int64(snapshotter.setting.MaxAge) {
+		return 0, SnapshotStaleError
+	}
+	restored := 0
+	for _, breakerSnapshot := range snapshot.Breakers {
+		broker, err := getBreakerManager(breakerSnapshot.Name)
+		if err != nil {
+			continue
+		}
+		broker.restore(breakerSnapshot)
+		restored++
+	}
+	return restored, nil
+}
+
+//Start 开始定时保存快照，重复调用会替换之前的任务
+func (snapshotter *snapshotter) Start() {
+	stop := make(chan struct{})
+	snapshotter.mutex.Lock()
+	if snapshotter.stop != nil {
+		close(snapshotter.stop)
+	}
+	snapshotter.stop = stop
+	snapshotter.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(snapshotter.setting.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = snapshotter.Save()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stop 停止定时保存并保存最后一次快照，服务退出时调用
+func (snapshotter *snapshotter) Stop() error {
+	snapshotter.mutex.Lock()
+	if snapshotter.stop != nil {
+		close(snapshotter.stop)
+		snapshotter.stop = nil
+	}
+	snapshotter.mutex.Unlock()
+	return snapshotter.Save()
+}
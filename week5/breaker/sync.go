@@ -0,0 +1,309 @@
+package breaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	DefaultSyncInterval = time.Second
+	DefaultSyncTTL      = 10 * time.Second
+	DefaultSyncPrefix   = "breaker:state:"
+)
+
+//SharedState 单个实例发布的熔断器状态，窗口计数只包含本实例的请求
+type SharedState struct {
+	//策略名
+	Name string `json:"name"`
+
+	//实例名
+	Instance string `json:"instance"`
+
+	//状态，只有关闭和打开，半开启由休眠结束时间计算
+	Status int32 `json:"status"`
+
+	//最近一次状态变化的时间，单位纳秒，多实例状态冲突时以最近变化的为准
+	Since int64 `json:"since"`
+
+	//休眠结束的时间，单位纳秒
+	CycleTime int64 `json:"cycle_time"`
+
+	//本实例的窗口计数
+	Window WindowStat `json:"window"`
+
+	//发布时间，单位纳秒
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+//StateStore 熔断器共享状态存储，多个实例通过同一存储交换状态及窗口计数
+type StateStore interface {
+	//Publish 发布本实例的状态
+	Publish(ctx context.Context, state SharedState) error
+
+	//Load 读取所有实例发布的指定策略的状态
+	Load(ctx context.Context, name string) ([]SharedState, error)
+}
+
+//基于Redis的共享状态存储，每个策略一个hash，field为实例名，value为json
+type redisStateStore struct {
+	//Redis客户端
+	client redis.UniversalClient
+
+	//key前缀
+	prefix string
+
+	//key过期时间，所有实例都停止发布后自动删除
+	ttl time.Duration
+}
+
+//NewRedisStateStore 创建基于Redis的共享状态存储，prefix为空时使用DefaultSyncPrefix，ttl小于等于0时使用DefaultSyncTTL
+func NewRedisStateStore(client redis.UniversalClient, prefix string, ttl time.Duration) StateStore {
+	if prefix == "" {
+		prefix = DefaultSyncPrefix
+	}
+	if ttl <= 0 {
+		ttl = DefaultSyncTTL
+	}
+	return &redisStateStore{client: client, prefix: prefix, ttl: ttl}
+}
+
+//Publish 写入本实例的状态并刷新key的过期时间
+func (store *redisStateStore) Publish(ctx context.Context, state SharedState) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	key := store.prefix + state.Name
+	_, err = store.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, state.Instance, value)
+		pipe.Expire(ctx, key, store.ttl)
+		return nil
+	})
+	return err
+}
+
+//Load 读取所有实例的状态，无法解析的值直接跳过
+func (store *redisStateStore) Load(ctx context.Context, name string) ([]SharedState, error) {
+	values, err := store.client.HGetAll(ctx, store.prefix+name).Result()
+	if err != nil {
+		return nil, err
+	}
+	states := make([]SharedState, 0, len(values))
+	for _, value := range values {
+		var state SharedState
+		if err := json.Unmarshal([]byte(value), &state); err != nil {
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+//SyncSetting 多实例状态同步设置
+type SyncSetting struct {
+	//共享状态存储
+	Store StateStore
+
+	//实例名，为空时使用主机名加进程号
+	Instance string
+
+	//同步间隔
+	Interval time.Duration
+
+	//其他实例的状态超过该时间未更新时忽略
+	TTL time.Duration
+
+	//需要同步的策略名，为空时同步管理器中的全部熔断器
+	Names []string
+}
+
+//本实例最近一次发布的状态
+type syncedStatus struct {
+	//状态
+	status int32
+
+	//状态变化时间，单位纳秒
+	since int64
+}
+
+//多实例状态同步，定时发布本实例的状态及窗口计数，并按其他实例的状态打开或关闭本实例的熔断器
+type stateSync struct {
+	//设置
+	setting SyncSetting
+
+	//各策略最近一次发布的状态
+	statuses map[string]syncedStatus
+
+	//保护statuses
+	mutex sync.Mutex
+
+	//停止通道
+	stop chan struct{}
+}
+
+//NewStateSync 创建多实例状态同步，未设置的字段使用默认值，调用Start开始同步
+func NewStateSync(setting SyncSetting) *stateSync {
+	if setting.Instance == "" {
+		hostname, _ := os.Hostname()
+		setting.Instance = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if setting.Interval <= 0 {
+		setting.Interval = DefaultSyncInterval
+	}
+	if setting.TTL <= 0 {
+		setting.TTL = DefaultSyncTTL
+	}
+	return &stateSync{
+		setting:  setting,
+		statuses: make(map[string]syncedStatus),
+	}
+}
+
+//Start 开始定时同步，重复调用会替换之前的同步任务
+func (stateSync *stateSync) Start() {
+	stop := make(chan struct{})
+	stateSync.mutex.Lock()
+	if stateSync.stop != nil {
+		close(stateSync.stop)
+	}
+	stateSync.stop = stop
+	stateSync.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(stateSync.setting.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), stateSync.setting.Interval)
+				_ = stateSync.SyncOnce(ctx)
+				cancel()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stop 停止同步
+func (stateSync *stateSync) Stop() {
+	stateSync.mutex.Lock()
+	defer stateSync.mutex.Unlock()
+	if stateSync.stop != nil {
+		close(stateSync.stop)
+		stateSync.stop = nil
+	}
+}
+
+//需要同步的熔断器
+func (stateSync *stateSync) breakers() []*breaker {
+	if len(stateSync.setting.Names) == 0 {
+		return bm.list()
+	}
+	breakers := make([]*breaker, 0, len(stateSync.setting.Names))
+	for _, name := range stateSync.setting.Names {
+		if broker, err := getBreakerManager(name); err == nil {
+			breakers = append(breakers, broker)
+		}
+	}
+	return breakers
+}
+
+//SyncOnce 同步一次全部熔断器，人工强制及自适应限流模式的熔断器不参与同步，返回遇到的第一个错误
+func (stateSync *stateSync) SyncOnce(ctx context.Context) error {
+	var firstErr error
+	for _, broker := range stateSync.breakers() {
+		if atomic.LoadInt32(&broker.forced) != forcedNone || broker.adaptiveK > 0 {
+			continue
+		}
+		if err := stateSync.sync(ctx, broker); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+//同步单个熔断器，先按其他实例的状态收敛本实例，再发布本实例的状态
+func (stateSync *stateSync) sync(ctx context.Context, broker *breaker) error {
+	now := broker.clock.Now().UnixNano()
+	status := broker.counter.GetStatus()
+
+	stateSync.mutex.Lock()
+	last, ok := stateSync.statuses[broker.name]
+	stateSync.mutex.Unlock()
+	//首次同步时关闭视为初始状态而不是状态变化，以便采用其他实例已有的打开状态
+	if !ok && status == StatusClosed {
+		last = syncedStatus{status: status}
+	} else if !ok || last.status != status {
+		last = syncedStatus{status: status, since: now}
+	}
+
+	peers, err := stateSync.setting.Store.Load(ctx, broker.name)
+	if err != nil {
+		return err
+	}
+	stat := broker.counter.GetWindowStat()
+	var latest *SharedState
+	for idx := range peers {
+		peer := &peers[idx]
+		if peer.Instance == stateSync.setting.Instance || now-peer.UpdatedAt > int64(stateSync.setting.TTL) {
+			continue
+		}
+		stat.Total += peer.Window.Total
+		stat.Failed += peer.Window.Failed
+		stat.Slow += peer.Window.Slow
+		if peer.Status != status && peer.Since > last.since && (latest == nil || peer.Since > latest.Since) {
+			latest = peer
+		}
+	}
+
+	switch {
+	case latest != nil:
+		//其他实例最近发生了状态变化，以其为准
+		broker.adoptState(latest.Status, latest.CycleTime, reasonSync)
+		last = syncedStatus{status: latest.Status, since: latest.Since}
+	case status == StatusClosed && stat.Failed > 0 && broker.counter.tripStrategy.ShouldTrip(stat):
+		//汇总所有实例的窗口计数后达到熔断条件
+		cycleTime := broker.clock.Now().Add(broker.sleepWindow).UnixNano()
+		broker.adoptState(StatusOpen, cycleTime, reasonSync)
+		last = syncedStatus{status: StatusOpen, since: now}
+	}
+
+	stateSync.mutex.Lock()
+	stateSync.statuses[broker.name] = last
+	stateSync.mutex.Unlock()
+
+	return stateSync.setting.Store.Publish(ctx, SharedState{
+		Name:      broker.name,
+		Instance:  stateSync.setting.Instance,
+		Status:    last.status,
+		Since:     last.since,
+		CycleTime: atomic.LoadInt64(&broker.cycleTime),
+		Window:    broker.counter.GetWindowStat(),
+		UpdatedAt: now,
+	})
+}
+
+//采用其他实例或快照的状态，打开时使用其休眠结束时间，关闭时清空半开启计数并补足令牌
+func (broker *breaker) adoptState(status int32, cycleTime int64, reason string) {
+	switch status {
+	case StatusOpen:
+		atomic.StoreInt64(&broker.cycleTime, cycleTime)
+		if atomic.SwapInt32(&broker.counter.status, StatusOpen) != StatusOpen {
+			broker.emitTransition(EventTrip, StatusClosed, StatusOpen, reason)
+		}
+	case StatusClosed:
+		broker.counter.clear()
+		//半开启中途采用关闭状态时令牌可能只用了一部分，需补足而不是等用完后归还
+		broker.lpm.Refill()
+		if atomic.SwapInt32(&broker.counter.status, StatusClosed) != StatusClosed {
+			broker.emitTransition(EventRecover, StatusOpen, StatusClosed, reason)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package breaker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//进程内的Redis替身，只实现状态同步用到的HSET、HGETALL及EXPIRE
+type fakeRedis struct {
+	mutex  sync.Mutex
+	hashes map[string]map[string]string
+}
+
+//启动Redis替身并返回地址
+func startFakeRedis(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	server := &fakeRedis{hashes: make(map[string]map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+//读取一条RESP数组命令
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected line %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for idx := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args[idx] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (server *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		server.mutex.Lock()
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "HSET":
+			hash, ok := server.hashes[args[1]]
+			if !ok {
+				hash = make(map[string]string)
+				server.hashes[args[1]] = hash
+			}
+			for idx := 2; idx+1 < len(args); idx += 2 {
+				hash[args[idx]] = args[idx+1]
+			}
+			reply = ":1\r\n"
+		case "HGETALL":
+			hash := server.hashes[args[1]]
+			reply = fmt.Sprintf("*%d\r\n", len(hash)*2)
+			for field, value := range hash {
+				reply += fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(field), field, len(value), value)
+			}
+		case "EXPIRE":
+			reply = ":1\r\n"
+		default:
+			reply = "+OK\r\n"
+		}
+		server.mutex.Unlock()
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func TestStateSyncConverges(t *testing.T) {
+	b, clock := newTestBreaker(t, NewBreakSettingInfo())
+	client := redis.NewClient(&redis.Options{Addr: startFakeRedis(t)})
+	defer client.Close()
+	store := NewRedisStateStore(client, "", 0)
+	stateSync := NewStateSync(SyncSetting{Store: store, Instance: "a", Names: []string{t.Name()}})
+	ctx := context.Background()
+	peer := func(status int32, window WindowStat) {
+		t.Helper()
+		now := clock.Now()
+		err := store.Publish(ctx, SharedState{Name: t.Name(), Instance: "b", Status: status, Since: now.UnixNano(),
+			CycleTime: now.Add(time.Second).UnixNano(), Window: window, UpdatedAt: now.UnixNano()})
+		if err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	syncOnce := func() {
+		t.Helper()
+		if err := stateSync.SyncOnce(ctx); err != nil {
+			t.Fatalf("SyncOnce() error = %v", err)
+		}
+	}
+
+	//其他实例熔断后本实例随之打开
+	syncOnce()
+	clock.Advance(100 * time.Millisecond)
+	peer(StatusOpen, WindowStat{})
+	syncOnce()
+	assertStatus(t, b, StatusOpen)
+	states, err := store.Load(ctx, t.Name())
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for _, state := range states {
+		if state.Instance == "a" && state.Status != StatusOpen {
+			t.Fatalf("published status = %d, want open", state.Status)
+		}
+	}
+
+	//其他实例恢复后本实例随之关闭
+	clock.Advance(100 * time.Millisecond)
+	peer(StatusClosed, WindowStat{})
+	syncOnce()
+	assertStatus(t, b, StatusClosed)
+
+	//汇总各实例的窗口计数达到熔断条件
+	clock.Advance(100 * time.Millisecond)
+	peer(StatusClosed, WindowStat{Total: 8, Failed: 6})
+	doN(t, 2, failRun)
+	assertStatus(t, b, StatusClosed)
+	syncOnce()
+	assertStatus(t, b, StatusOpen)
+}
+
+func TestStateSyncAdoptClosedDuringHalfOpen(t *testing.T) {
+	b, clock := newTestBreaker(t, NewBreakSettingInfo().SetBreakerTestMax(4))
+	client := redis.NewClient(&redis.Options{Addr: startFakeRedis(t)})
+	defer client.Close()
+	store := NewRedisStateStore(client, "", 0)
+	stateSync := NewStateSync(SyncSetting{Store: store, Instance: "a", Names: []string{t.Name()}})
+	ctx := context.Background()
+
+	doN(t, DefaultMinRequests, failRun)
+	assertStatus(t, b, StatusOpen)
+	if err := stateSync.SyncOnce(ctx); err != nil {
+		t.Fatalf("SyncOnce() error = %v", err)
+	}
+
+	//半开启时放行两个探测请求后，其他实例恢复为关闭
+	clock.Advance(time.Second + time.Millisecond)
+	if errs := doN(t, 2, successRun); len(errs) != 0 {
+		t.Fatalf("probe fallback errors = %v", errs)
+	}
+	now := clock.Now()
+	err := store.Publish(ctx, SharedState{Name: t.Name(), Instance: "b", Status: StatusClosed, Since: now.UnixNano(),
+		CycleTime: now.UnixNano(), UpdatedAt: now.UnixNano()})
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := stateSync.SyncOnce(ctx); err != nil {
+		t.Fatalf("SyncOnce() error = %v", err)
+	}
+	assertStatus(t, b, StatusClosed)
+	if got := b.lpm.GetRemainder(); got != 4 {
+		t.Fatalf("tickets = %d, want 4", got)
+	}
+
+	//再次熔断后完整的半开启轮次可以恢复
+	doN(t, 1, failRun)
+	assertStatus(t, b, StatusOpen)
+	clock.Advance(time.Second + time.Millisecond)
+	if errs := doN(t, 4, successRun); len(errs) != 0 {
+		t.Fatalf("probe fallback errors = %v", errs)
+	}
+	assertStatus(t, b, StatusClosed)
+}
@@ -0,0 +1,117 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+//TransportOpenError Transport在熔断时返回的错误，可用errors.Is(err, OpenError)判断
+type TransportOpenError struct {
+	//策略名
+	Name string
+}
+
+//Error 返回错误信息
+func (transportOpenError *TransportOpenError) Error() string {
+	return fmt.Sprintf("breaker %q open", transportOpenError.Name)
+}
+
+//Unwrap 返回OpenError
+func (transportOpenError *TransportOpenError) Unwrap() error {
+	return OpenError
+}
+
+//TransportBulkheadError Transport在舱壁隔离已满时返回的错误，可用errors.Is(err, ErrBulkheadFull)判断
+type TransportBulkheadError struct {
+	//策略名
+	Name string
+}
+
+//Error 返回错误信息
+func (transportBulkheadError *TransportBulkheadError) Error() string {
+	return fmt.Sprintf("breaker %q bulkhead full", transportBulkheadError.Name)
+}
+
+//Unwrap 返回ErrBulkheadFull
+func (transportBulkheadError *TransportBulkheadError) Unwrap() error {
+	return ErrBulkheadFull
+}
+
+//Transport 经过熔断器发送请求的http.RoundTripper，赋值给http.Client.Transport即可透明熔断
+type Transport struct {
+	//实际发送请求的RoundTripper，为空时使用http.DefaultTransport
+	Base http.RoundTripper
+
+	//返回请求的策略名，为空时按Host区分
+	NameFunc func(req *http.Request) string
+
+	//判断响应状态码是否计为失败，为空时5xx计为失败
+	IsFailureStatus func(status int) bool
+}
+
+//FailureStatusCodes 返回只将指定状态码计为失败的判定函数
+func FailureStatusCodes(codes ...int) func(status int) bool {
+	failure := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		failure[code] = true
+	}
+	return func(status int) bool {
+		return failure[status]
+	}
+}
+
+//RoundTrip 执行一次请求，熔断时返回*TransportOpenError，舱壁隔离已满时返回*TransportBulkheadError
+//状态码计为失败时响应仍正常返回给调用方，只在熔断器中计为失败
+func (transport *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	name := req.URL.Host
+	if transport.NameFunc != nil {
+		name = transport.NameFunc(req)
+	}
+	isFailureStatus := transport.IsFailureStatus
+	if isFailureStatus == nil {
+		isFailureStatus = func(status int) bool {
+			return status >= http.StatusInternalServerError
+		}
+	}
+
+	var resp *http.Response
+	var rejectErr error
+	err := Do(req.Context(), name, func() error {
+		var err error
+		resp, err = base.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		if isFailureStatus(resp.StatusCode) {
+			return fmt.Errorf("%w: %d", HttpStatusError, resp.StatusCode)
+		}
+		return nil
+	}, func(err error) {
+		switch {
+		case errors.Is(err, OpenError):
+			rejectErr = &TransportOpenError{Name: name}
+		case errors.Is(err, ErrBulkheadFull):
+			rejectErr = &TransportBulkheadError{Name: name}
+		}
+	})
+	if rejectErr != nil {
+		//未发送的请求也需要关闭请求体
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, rejectErr
+	}
+	if err != nil && !errors.Is(err, HttpStatusError) {
+		//判定函数panic等情况下已收到的响应不再返回，需要关闭响应体
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		return nil, err
+	}
+	return resp, nil
+}
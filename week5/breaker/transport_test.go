@@ -0,0 +1,156 @@
+package breaker
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+//记录是否被关闭的请求体或响应体
+type trackedBody struct {
+	*strings.Reader
+	closed bool
+}
+
+func (body *trackedBody) Close() error {
+	body.closed = true
+	return nil
+}
+
+//函数形式的RoundTripper
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+//启动返回指定状态码的服务并返回经过熔断器的客户端
+func newTransportClient(t *testing.T, status *int32, calls *int32, isFailureStatus func(status int) bool) (*http.Client, string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		w.WriteHeader(int(atomic.LoadInt32(status)))
+		_, _ = w.Write([]byte("body"))
+	}))
+	t.Cleanup(server.Close)
+	client := &http.Client{Transport: &Transport{
+		NameFunc: func(req *http.Request) string {
+			return t.Name()
+		},
+		IsFailureStatus: isFailureStatus,
+	}}
+	return client, server.URL
+}
+
+func TestTransportStatusClassification(t *testing.T) {
+	b, _ := newTestBreaker(t, NewBreakSettingInfo())
+	status, calls := int32(http.StatusInternalServerError), int32(0)
+	client, url := newTransportClient(t, &status, &calls, FailureStatusCodes(http.StatusTooManyRequests))
+
+	//不在失败状态码中的5xx不计为失败
+	for i := 0; i < DefaultMinRequests; i++ {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+	assertStatus(t, b, StatusClosed)
+
+	//计为失败的响应仍完整返回给调用方
+	atomic.StoreInt32(&status, http.StatusTooManyRequests)
+	for i := 0; i < DefaultMinRequests; i++ {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests || string(data) != "body" {
+			t.Fatalf("response = %d %q, want 429 body", resp.StatusCode, data)
+		}
+	}
+	assertStatus(t, b, StatusOpen)
+}
+
+func TestTransportOpenError(t *testing.T) {
+	newTestBreaker(t, NewBreakSettingInfo())
+	status, calls := int32(http.StatusOK), int32(0)
+	client, url := newTransportClient(t, &status, &calls, nil)
+	ForceOpen(t.Name())
+
+	body := &trackedBody{Reader: strings.NewReader("payload")}
+	req, _ := http.NewRequest(http.MethodPost, url, body)
+	_, err := client.Do(req)
+	var openErr *TransportOpenError
+	if !errors.As(err, &openErr) || openErr.Name != t.Name() || !errors.Is(err, OpenError) {
+		t.Fatalf("Do() error = %v, want *TransportOpenError", err)
+	}
+	if !body.closed {
+		t.Fatal("request body not closed when open")
+	}
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Fatalf("server calls = %d, want 0", got)
+	}
+}
+
+func TestTransportClosesResponseOnError(t *testing.T) {
+	newTestBreaker(t, NewBreakSettingInfo())
+	body := &trackedBody{Reader: strings.NewReader("body")}
+	transport := &Transport{
+		Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: body}, nil
+		}),
+		NameFunc: func(req *http.Request) string {
+			return t.Name()
+		},
+		IsFailureStatus: func(status int) bool {
+			panic("classifier")
+		},
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://example.invalid", nil)
+	resp, err := transport.RoundTrip(req)
+	if resp != nil || !errors.Is(err, PanicError) {
+		t.Fatalf("RoundTrip() = %v, %v, want nil, PanicError", resp, err)
+	}
+	if !body.closed {
+		t.Fatal("response body not closed on error")
+	}
+}
+
+func TestTransportBulkheadError(t *testing.T) {
+	newTestBreaker(t, NewBreakSettingInfo().SetBulkhead(1, 0, 0))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &Transport{NameFunc: func(req *http.Request) string {
+		return t.Name()
+	}}}
+
+	first := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		first <- err
+	}()
+	<-started
+	resp, err := client.Get(server.URL)
+	var bulkheadErr *TransportBulkheadError
+	if resp != nil || !errors.As(err, &bulkheadErr) || !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("Get() = %v, %v, want *TransportBulkheadError", resp, err)
+	}
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("first Get() error = %v", err)
+	}
+}
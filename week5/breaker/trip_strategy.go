@@ -0,0 +1,94 @@
+package breaker
+
+//WindowStat 滑动窗口统计数据
+type WindowStat struct {
+	//窗口内请求总数
+	Total uint32 `json:"total"`
+
+	//窗口内失败请求数
+	Failed uint32 `json:"failed"`
+
+	//窗口内慢调用数
+	Slow uint32 `json:"slow"`
+
+	//窗口内自适应限流拒绝的请求数，不计入Total
+	Rejected uint32 `json:"rejected"`
+
+	//连续失败数，成功一次后清零
+	ConsecutiveFailures uint32 `json:"consecutive_failures"`
+}
+
+//FailPercent 返回窗口内错误率
+func (windowStat WindowStat) FailPercent() int {
+	if windowStat.Total == 0 {
+		return 0
+	}
+	return int(float32(windowStat.Failed)/float32(windowStat.Total)*100 + 0.5)
+}
+
+//SlowPercent 返回窗口内慢调用比例
+func (windowStat WindowStat) SlowPercent() int {
+	if windowStat.Total == 0 {
+		return 0
+	}
+	return int(float32(windowStat.Slow)/float32(windowStat.Total)*100 + 0.5)
+}
+
+//TripStrategy 熔断策略，每次失败计数后根据窗口统计判断是否需要熔断
+type TripStrategy interface {
+	ShouldTrip(stat WindowStat) bool
+}
+
+//错误率熔断策略
+type errorRateStrategy struct {
+	//错误率
+	percent int
+
+	//最小请求数
+	minRequests uint32
+}
+
+//NewErrorRateStrategy 错误率熔断策略，窗口内请求数达到minRequests且错误率达到percent时熔断
+func NewErrorRateStrategy(percent int, minRequests int) TripStrategy {
+	return &errorRateStrategy{percent: percent, minRequests: uint32(minRequests)}
+}
+
+//ShouldTrip 判断是否熔断
+func (strategy *errorRateStrategy) ShouldTrip(stat WindowStat) bool {
+	if stat.Total < strategy.minRequests {
+		return false
+	}
+	return stat.FailPercent() >= strategy.percent
+}
+
+//连续失败熔断策略
+type consecutiveFailuresStrategy struct {
+	//连续失败次数
+	threshold uint32
+}
+
+//NewConsecutiveFailuresStrategy 连续失败熔断策略，连续失败threshold次时熔断
+func NewConsecutiveFailuresStrategy(threshold int) TripStrategy {
+	return &consecutiveFailuresStrategy{threshold: uint32(threshold)}
+}
+
+//ShouldTrip 判断是否熔断
+func (strategy *consecutiveFailuresStrategy) ShouldTrip(stat WindowStat) bool {
+	return stat.ConsecutiveFailures >= strategy.threshold
+}
+
+//失败数熔断策略
+type failureCountStrategy struct {
+	//窗口内失败次数
+	threshold uint32
+}
+
+//NewFailureCountStrategy 失败数熔断策略，窗口内失败数达到threshold时熔断
+func NewFailureCountStrategy(threshold int) TripStrategy {
+	return &failureCountStrategy{threshold: uint32(threshold)}
+}
+
+//ShouldTrip 判断是否熔断
+func (strategy *failureCountStrategy) ShouldTrip(stat WindowStat) bool {
+	return stat.Failed >= strategy.threshold
+}
@@ -2,225 +2,187 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"geek-time/week5/breaker"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"sync"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func main() {
-	//模拟并发时某台机器有问题
-	var wg sync.WaitGroup
-	for i := 0; i < 100; i++ {
-		wg.Add(1)
-		go func(i int, wg *sync.WaitGroup) {
-			defer wg.Done()
-			_ = breaker.Do(context.Background(), "test", func() error {
-				url := "https://www.baidu.com/"
-				if i%2 == 0 {
-					url = "https://www.baidu1.com"
-				}
-				_, err := http.Get(url)
-				if err != nil {
-					return err
-				}
-				return nil
-			}, func(err error) {
-				fmt.Println("并发 callback", err)
-			})
-		}(i, &wg)
-	}
-	wg.Wait()
+//模拟的下游错误
+var errDependency = errors.New("dependency error")
 
-	//time.Sleep(time.Millisecond * 2000)
-	time.Sleep(time.Second * 2)
-	//查看熔断是否成功
-	var wg2 sync.WaitGroup
-	for i := 0; i < 100; i++ {
-		wg2.Add(1)
-		go func(i int, wg2 *sync.WaitGroup) {
-			defer wg2.Done()
-			err := breaker.Do(context.Background(), "test", func() error {
-				url := "https://www.baidu.com/"
-				resp, err := http.Get(url)
-				if err != nil {
-					return err
-				}
+//模拟阶段，持续时间内下游按错误率返回错误，耗时在latency的0.5到1.5倍之间
+type phase struct {
+	duration  time.Duration
+	errorRate float64
+	latency   time.Duration
+}
 
-				defer func(Body io.ReadCloser) {
-					_ = Body.Close()
-				}(resp.Body)
-				if _, err := ioutil.ReadAll(resp.Body); err != nil {
-					return err
-				}
-				return nil
-			}, func(err error) {
-				fmt.Println("并发2 callback", err)
-			})
-			if err != nil {
-				fmt.Println("并发2 error", err, i)
-			}
-		}(i, &wg2)
+//解析模拟脚本，格式为"持续时间:错误率:耗时"，多个阶段用逗号分隔，如"60s:0.02:20ms,60s:0.6:300ms"
+func parseScript(script string) ([]phase, error) {
+	var phases []phase
+	for _, item := range strings.Split(script, ",") {
+		fields := strings.Split(strings.TrimSpace(item), ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("phase %q: want duration:errorRate:latency", item)
+		}
+		duration, err := time.ParseDuration(fields[0])
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("phase %q: invalid duration", item)
+		}
+		errorRate, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || errorRate < 0 || errorRate > 1 {
+			return nil, fmt.Errorf("phase %q: error rate must be between 0 and 1", item)
+		}
+		latency, err := time.ParseDuration(fields[2])
+		if err != nil || latency < 0 {
+			return nil, fmt.Errorf("phase %q: invalid latency", item)
+		}
+		phases = append(phases, phase{duration: duration, errorRate: errorRate, latency: latency})
 	}
-	wg2.Wait()
+	return phases, nil
+}
 
-	//睡眠到休眠结束
-	time.Sleep(time.Second * 28)
-	//查看半开启是否成功
-	var wg3 sync.WaitGroup
-	for i := 0; i < 100; i++ {
-		wg3.Add(1)
-		go func(i int, wg3 *sync.WaitGroup) {
-			defer wg3.Done()
-			_ = breaker.Do(context.Background(), "test", func() error {
-				url := "https://www.baidu.com/"
-				resp, err := http.Get(url)
-				if err != nil {
-					return err
-				}
+//一个统计区间内的请求结果
+type report struct {
+	requests int
+	rejected int
+	failed   int
+}
 
-				defer func(Body io.ReadCloser) {
-					_ = Body.Close()
-				}(resp.Body)
-				if _, err := ioutil.ReadAll(resp.Body); err != nil {
-					return err
-				}
-				return nil
-			}, func(err error) {
-				if err != nil {
-					fmt.Println("并发3 callback", err)
-				} else {
-					fmt.Println("并发3 ok")
-				}
-			})
-		}(i, &wg3)
-	}
-	wg3.Wait()
+func main() {
+	script := flag.String("script", "60s:0.02:20ms,60s:0.6:300ms,60s:0.3:100ms,120s:0.01:20ms", "模拟脚本，持续时间:错误率:耗时，逗号分隔")
+	rps := flag.Int("rps", 50, "每秒请求数")
+	reportEvery := flag.Duration("report", 10*time.Second, "时间线输出间隔")
+	seed := flag.Int64("seed", 1, "随机数种子，相同种子输出相同")
+	interval := flag.Duration("interval", 10*time.Second, "熔断统计周期")
+	gridNum := flag.Int("grid", 10, "统计周期格子数")
+	sleepWindow := flag.Duration("sleep", 5*time.Second, "熔断休眠时间")
+	errorPercent := flag.Int("error-percent", 50, "熔断错误率")
+	halfOpenPercent := flag.Int("half-open-percent", 50, "半开启错误率")
+	minRequests := flag.Int("min-requests", 10, "熔断需要的最小请求数")
+	testMax := flag.Int("test-max", 20, "半开启探测请求数")
+	slowCall := flag.Duration("slow", 0, "慢调用耗时阈值，为0时不启用慢调用熔断")
+	slowPercent := flag.Int("slow-percent", 0, "慢调用比例")
+	flag.Parse()
 
-	//查看恢复是否成功
-	var wg4 sync.WaitGroup
-	for i := 0; i < 100; i++ {
-		wg4.Add(1)
-		go func(i int, wg4 *sync.WaitGroup) {
-			defer wg4.Done()
-			_ = breaker.Do(context.Background(), "test", func() error {
-				url := "https://www.baidu.com/"
-				_, err := http.Get(url)
-				if err != nil {
-					return err
-				}
-				return nil
-			}, func(err error) {
-				if err != nil {
-					fmt.Println("并发4 callback", err)
-				} else {
-					fmt.Println("并发4 ok")
-				}
-			})
-		}(i, &wg4)
+	phases, err := parseScript(*script)
+	if err != nil {
+		fmt.Println("parse script err:", err)
+		os.Exit(2)
+	}
+	if *rps <= 0 || *reportEvery <= 0 {
+		fmt.Println("rps and report must be positive")
+		os.Exit(2)
 	}
-	wg4.Wait()
-
-	time.Sleep(time.Second * 2)
 
-	//睡眠到下一次统计周期
-	time.Sleep(time.Second * 60)
-	//再次模拟正常流量
-	var wg5 sync.WaitGroup
-	for i := 0; i < 100; i++ {
-		wg5.Add(1)
-		go func(i int, wg5 *sync.WaitGroup) {
-			defer wg5.Done()
-			_ = breaker.Do(context.Background(), "test", func() error {
-				url := "https://www.baidu.com/"
-				resp, err := http.Get(url)
-				if err != nil {
-					return err
-				}
+	start := time.Unix(0, 0).UTC()
+	clock := breaker.NewManualClock(start)
+	const name = "simulation"
+	opts := []breaker.Option{
+		breaker.WithClock(clock),
+		breaker.WithWindow(*interval, *gridNum),
+		breaker.WithSleepWindow(*sleepWindow),
+		breaker.WithErrorPercentThreshold(*errorPercent),
+		breaker.WithBreakerErrorPercentThreshold(*halfOpenPercent),
+		breaker.WithMinRequests(*minRequests),
+		breaker.WithBreakerTestMax(*testMax),
+	}
+	if *slowCall > 0 {
+		opts = append(opts, breaker.WithSlowCall(*slowCall, *slowPercent))
+	}
+	if _, err := breaker.Register(name, opts...); err != nil {
+		fmt.Println("register breaker err:", err)
+		os.Exit(2)
+	}
+	events, cancel := breaker.Subscribe(0)
+	defer cancel()
 
-				defer func(Body io.ReadCloser) {
-					err := Body.Close()
-					if err != nil {
+	random := rand.New(rand.NewSource(*seed))
+	gap := time.Second / time.Duration(*rps)
+	var current report
+	var total report
+	var now time.Duration
+	nextReport := *reportEvery
 
-					}
-				}(resp.Body)
-				if _, err := ioutil.ReadAll(resp.Body); err != nil {
-					return err
+	fmt.Printf("%8s  %-5s  %-9s  %8s  %8s  %8s  %7s  %s\n", "time", "err", "state", "requests", "rejected", "failed", "reject", "window")
+	for _, phase := range phases {
+		for end := now + phase.duration; now < end; now += gap {
+			clock.Set(start.Add(now))
+			//按脚本决定本次调用结果，耗时通过推进虚拟时钟模拟，调用结束后回到请求开始时间
+			fail := random.Float64() < phase.errorRate
+			latency := time.Duration(float64(phase.latency) * (0.5 + random.Float64()))
+			current.requests++
+			_ = breaker.Do(context.Background(), name, func() error {
+				clock.Advance(latency)
+				if fail {
+					return errDependency
 				}
 				return nil
 			}, func(err error) {
-				if err != nil {
-					fmt.Println("并发5 callback", err)
+				if errors.Is(err, breaker.OpenError) {
+					current.rejected++
 				} else {
-					fmt.Println("并发5 ok")
+					current.failed++
 				}
 			})
-		}(i, &wg5)
-	}
-	wg5.Wait()
+			printEvents(events, start)
 
-	//再次模拟错误流量
-	var wg6 sync.WaitGroup
-	for i := 0; i < 200; i++ {
-		wg6.Add(1)
-		go func(i int, wg6 *sync.WaitGroup) {
-			defer wg6.Done()
-			_ = breaker.Do(context.Background(), "test", func() error {
-				url := "https://www.baidu1.com/"
-				resp, err := http.Get(url)
-				if err != nil {
-					return err
-				}
+			if now+gap >= nextReport {
+				info := infoOf(name)
+				fmt.Printf("%8s  %5.2f  %-9s  %8d  %8d  %8d  %6.1f%%  total=%d failed=%d slow=%d\n",
+					now+gap, phase.errorRate, info.State, current.requests, current.rejected, current.failed,
+					percent(current.rejected, current.requests), info.Window.Total, info.Window.Failed, info.Window.Slow)
+				total.requests += current.requests
+				total.rejected += current.rejected
+				total.failed += current.failed
+				current = report{}
+				nextReport += *reportEvery
+			}
+		}
+	}
+	total.requests += current.requests
+	total.rejected += current.rejected
+	total.failed += current.failed
+	fmt.Printf("summary: requests=%d rejected=%d (%.1f%%) failed=%d (%.1f%%)\n", total.requests,
+		total.rejected, percent(total.rejected, total.requests), total.failed, percent(total.failed, total.requests))
+}
 
-				defer func(Body io.ReadCloser) {
-					_ = Body.Close()
-				}(resp.Body)
-				if _, err := ioutil.ReadAll(resp.Body); err != nil {
-					return err
-				}
-				return nil
-			}, func(err error) {
-				if err != nil {
-					fmt.Println("并发6 callback", err)
-				} else {
-					fmt.Println("并发6 ok")
-				}
-			})
-		}(i, &wg6)
+//输出状态变化事件，探测结果较多时不逐条输出
+func printEvents(events <-chan breaker.Event, start time.Time) {
+	for {
+		select {
+		case event := <-events:
+			if event.Type == breaker.EventProbeSuccess || event.Type == breaker.EventProbeFailure {
+				continue
+			}
+			fmt.Printf("%8s  event %s %s -> %s (window total=%d failed=%d)\n", event.Time.Sub(start).Truncate(time.Millisecond), event.Type,
+				event.From, event.To, event.Window.Total, event.Window.Failed)
+		default:
+			return
+		}
 	}
-	wg6.Wait()
+}
 
-	//再次查看熔断是否成功
-	var wg7 sync.WaitGroup
-	for i := 0; i < 100; i++ {
-		wg7.Add(1)
-		go func(i int, wg7 *sync.WaitGroup) {
-			defer wg7.Done()
-			_ = breaker.Do(context.Background(), "test", func() error {
-				url := "https://www.baidu.com/"
-				resp, err := http.Get(url)
-				if err != nil {
-					return err
-				}
+//查找熔断器状态
+func infoOf(name string) breaker.BreakerInfo {
+	for _, info := range breaker.Infos() {
+		if info.Name == name {
+			return info
+		}
+	}
+	return breaker.BreakerInfo{}
+}
 
-				defer func(Body io.ReadCloser) {
-					_ = Body.Close()
-				}(resp.Body)
-				if _, err := ioutil.ReadAll(resp.Body); err != nil {
-					return err
-				}
-				return nil
-			}, func(err error) {
-				if err != nil {
-					fmt.Println("并发7 callback", err)
-				} else {
-					fmt.Println("并发7 ok")
-				}
-			})
-		}(i, &wg7)
+//百分比
+func percent(n int, total int) float64 {
+	if total == 0 {
+		return 0
 	}
-	wg7.Wait()
+	return float64(n) * 100 / float64(total)
 }